@@ -0,0 +1,364 @@
+// Package ast declares the types used to represent the syntax tree of the rules.
+//
+// The positions of the nodes are the token.Pos values reported by the scanner of the parser.
+// The rules are scanned as a single file whose base is 1, so the position of a node is its
+// offset in the rules plus one.
+package ast
+
+import (
+	"errors"
+	"fmt"
+	"go/token"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Node is implemented by all the nodes of the syntax tree.
+type Node interface {
+	Pos() token.Pos // position of the first character belonging to the node
+	End() token.Pos // position of the first character immediately after the node
+}
+
+// Expr is implemented by all the expression nodes.
+type Expr interface {
+	Node
+	exprNode()
+}
+
+// Ident is the operand of a rule, which is bound to the field of the context carrying the
+// same struct tag.
+type Ident struct {
+	NamePos token.Pos // identifier position
+	Name    string    // identifier name
+}
+
+// LitKind is the kind of a literal value.
+type LitKind int
+
+const (
+	Invalid LitKind = iota
+	Int             // integer such as 10, -3 or 0x1f
+	Float           // floating-point number such as 2.5 or -1e3
+	Bool            // true or false
+	String          // string quoted by "`"
+	List            // list of literals such as [`a`, `b`] or (1, 2, 3)
+	Nil             // nil
+	Version         // semantic version written without quotes such as 1.3.2 or v2.0.0-rc.1
+)
+
+var litKinds = [...]string{
+	Invalid: "invalid",
+	Int:     "int",
+	Float:   "float",
+	Bool:    "bool",
+	String:  "string",
+	List:    "list",
+	Nil:     "nil",
+	Version: "version",
+}
+
+func (k LitKind) String() string {
+	if k < 0 || int(k) >= len(litKinds) {
+		return "LitKind(" + strconv.Itoa(int(k)) + ")"
+	}
+	return litKinds[k]
+}
+
+// BasicLit is the value of a rule. The literal is converted once when it is parsed and the
+// typed value is kept in Val.
+//
+// A list is a BasicLit of kind List holding its elements in Elts. Its Value joins the values of
+// the elements with ",", which is the value handed to the operation methods, and its Val holds
+// the typed values of the elements.
+type BasicLit struct {
+	ValuePos token.Pos   // literal position, the position of the opening bracket of a list
+	Kind     LitKind     // kind of the literal
+	Value    string      // literal value as written, without the quotes of a string
+	Val      interface{} // int64 (or uint64 beyond the range of int64), float64, bool, string (also of a version), []interface{} or nil
+	Elts     []*BasicLit // elements of a list
+	Rbrack   token.Pos   // position of the closing bracket of a list
+}
+
+// NewBasicLit converts the literal value of the given kind and returns the literal.
+func NewBasicLit(pos token.Pos, kind LitKind, value string) (*BasicLit, error) {
+	var val interface{}
+	var err error
+
+	switch kind {
+	case Int:
+		// decimal integers keep their leading zeros instead of being taken as octal numbers
+		base := 10
+		if digits := strings.TrimPrefix(value, "-"); len(digits) > 1 && digits[0] == '0' &&
+			strings.ContainsAny(digits[1:2], "xXoObB") {
+			base = 0
+		}
+		var i int64
+		if i, err = strconv.ParseInt(value, base, 64); err == nil {
+			val = i
+		} else if u, uerr := strconv.ParseUint(value, base, 64); uerr == nil {
+			val, err = u, nil
+		}
+	case Float:
+		var f float64
+		if f, err = strconv.ParseFloat(value, 64); err == nil && (math.IsInf(f, 0) || math.IsNaN(f)) {
+			err = errors.New("not finite")
+		}
+		val = f
+	case Bool:
+		var b bool
+		if value == "true" || value == "false" {
+			b = value == "true"
+		} else {
+			err = errors.New("neither true nor false")
+		}
+		val = b
+	case String:
+		val = value
+	case Nil:
+		if value != "nil" {
+			err = errors.New("not nil")
+		}
+	case Version:
+		// the version is verified by the parser, which compares it by semantic versioning
+		if value == "" {
+			err = errors.New("empty")
+		}
+		val = value
+	default:
+		err = errors.New("unknown kind")
+	}
+
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("%s is not a valid %s literal at %d", value, kind, pos))
+	}
+	return &BasicLit{ValuePos: pos, Kind: kind, Value: value, Val: val}, nil
+}
+
+// NewListLit returns the list of the elements, which must be literals of the same kind except
+// that integers and floating-point numbers may be mixed.
+func NewListLit(lbrack token.Pos, elts []*BasicLit, rbrack token.Pos) (*BasicLit, error) {
+	if len(elts) == 0 {
+		return nil, errors.New(fmt.Sprintf("empty list at %d", lbrack))
+	}
+
+	values := make([]string, len(elts))
+	val := make([]interface{}, len(elts))
+	for i, elt := range elts {
+		if elt == nil || elt.Kind == Invalid || elt.Kind == List || elt.Kind == Nil {
+			return nil, errors.New(fmt.Sprintf("invalid element of the list at %d", lbrack))
+		}
+		numeric := (elt.Kind == Int || elt.Kind == Float) && (elts[0].Kind == Int || elts[0].Kind == Float)
+		if elt.Kind != elts[0].Kind && !numeric {
+			return nil, errors.New(fmt.Sprintf("%s literal %s does not match the %s elements of the list at %d",
+				elt.Kind, elt.Value, elts[0].Kind, elt.ValuePos))
+		}
+		values[i], val[i] = elt.Value, elt.Val
+	}
+
+	return &BasicLit{
+		ValuePos: lbrack,
+		Kind:     List,
+		Value:    strings.Join(values, ","),
+		Val:      val,
+		Elts:     elts,
+		Rbrack:   rbrack,
+	}, nil
+}
+
+// SelectorExpr is a dotted operand such as "device.os", which is bound to the field tagged Sel
+// of the struct X is bound to.
+type SelectorExpr struct {
+	X   Expr   // *Ident or *SelectorExpr
+	Sel *Ident // field selector
+}
+
+// IndexExpr looks up an entry of a map operand by its key, such as "labels[`env`]". The key is a
+// string or an integer literal.
+type IndexExpr struct {
+	X      Expr      // *Ident, *SelectorExpr or *IndexExpr
+	Lbrack token.Pos // position of "["
+	Index  *BasicLit // key of the entry
+	Rbrack token.Pos // position of "]"
+}
+
+// CallExpr applies a function to the operand of a rule, such as "any(tags)". The function is
+// one of "any", "all" and "len".
+type CallExpr struct {
+	Fun    *Ident    // function name
+	Lparen token.Pos // position of "("
+	Arg    Expr      // *Ident or *SelectorExpr
+	Rparen token.Pos // position of ")"
+}
+
+// IsFunc tells whether the name is a function which can be applied to the operand of a rule.
+func IsFunc(name string) bool {
+	return name == "any" || name == "all" || name == "len"
+}
+
+// IsCallOp tells whether the operation can be written as a call taking the operand and the value,
+// such as "has_key(labels, `team`)".
+func IsCallOp(name string) bool {
+	return name == "has_key"
+}
+
+// IsUnaryOp tells whether the operation takes no value, such as "coupon exists".
+func IsUnaryOp(name string) bool {
+	return name == "exists"
+}
+
+// CmpExpr is a single rule in form of "operand operation value". A rule written as a call such as
+// "has_key(labels, `team`)" has the positions of its parentheses recorded, see IsCallOp, and a
+// rule whose operation takes no value has none, see IsUnaryOp.
+type CmpExpr struct {
+	X      Expr      // operand, *Ident, *SelectorExpr, *IndexExpr or *CallExpr
+	OpPos  token.Pos // position of the operation
+	Op     string    // operation, a comparison operator such as "<=", "=~" or an identifier such as "in"
+	Y      *BasicLit // value, nil for an operation taking no value
+	Lparen token.Pos // position of "(" of a call, or token.NoPos
+	Rparen token.Pos // position of ")" of a call, or token.NoPos
+}
+
+// IsCall tells whether the rule is written as a call.
+func (x *CmpExpr) IsCall() bool {
+	return x.Lparen.IsValid()
+}
+
+// UnaryExpr is the negation of an expression.
+type UnaryExpr struct {
+	OpPos token.Pos   // position of Op
+	Op    token.Token // token.NOT
+	X     Expr        // operand
+}
+
+// BinaryExpr combines two expressions with a logical operator.
+type BinaryExpr struct {
+	X     Expr        // left operand
+	OpPos token.Pos   // position of Op
+	Op    token.Token // token.LAND, token.LOR or token.SEMICOLON
+	Y     Expr        // right operand
+}
+
+// ParenExpr is a parenthesized expression.
+type ParenExpr struct {
+	Lparen token.Pos // position of "("
+	X      Expr      // parenthesized expression
+	Rparen token.Pos // position of ")"
+}
+
+func (x *Ident) Pos() token.Pos        { return x.NamePos }
+func (x *SelectorExpr) Pos() token.Pos { return x.X.Pos() }
+func (x *IndexExpr) Pos() token.Pos    { return x.X.Pos() }
+func (x *CallExpr) Pos() token.Pos     { return x.Fun.Pos() }
+func (x *BasicLit) Pos() token.Pos     { return x.ValuePos }
+func (x *CmpExpr) Pos() token.Pos {
+	if x.IsCall() {
+		return x.OpPos
+	}
+	return x.X.Pos()
+}
+func (x *UnaryExpr) Pos() token.Pos  { return x.OpPos }
+func (x *BinaryExpr) Pos() token.Pos { return x.X.Pos() }
+func (x *ParenExpr) Pos() token.Pos  { return x.Lparen }
+
+func (x *Ident) End() token.Pos        { return token.Pos(int(x.NamePos) + len(x.Name)) }
+func (x *SelectorExpr) End() token.Pos { return x.Sel.End() }
+func (x *IndexExpr) End() token.Pos    { return x.Rbrack + 1 }
+func (x *CallExpr) End() token.Pos     { return x.Rparen + 1 }
+func (x *BasicLit) End() token.Pos {
+	if x.Kind == List {
+		return x.Rbrack + 1
+	}
+	if x.Kind == String {
+		return token.Pos(int(x.ValuePos) + len(x.Value) + 2)
+	}
+	return token.Pos(int(x.ValuePos) + len(x.Value))
+}
+func (x *CmpExpr) End() token.Pos {
+	if x.IsCall() {
+		return x.Rparen + 1
+	}
+	if x.Y == nil {
+		return token.Pos(int(x.OpPos) + len(x.Op))
+	}
+	return x.Y.End()
+}
+func (x *UnaryExpr) End() token.Pos  { return x.X.End() }
+func (x *BinaryExpr) End() token.Pos { return x.Y.End() }
+func (x *ParenExpr) End() token.Pos  { return x.Rparen + 1 }
+
+func (*Ident) exprNode()        {}
+func (*SelectorExpr) exprNode() {}
+func (*IndexExpr) exprNode()    {}
+func (*CallExpr) exprNode()     {}
+func (*BasicLit) exprNode()     {}
+func (*CmpExpr) exprNode()      {}
+func (*UnaryExpr) exprNode()    {}
+func (*BinaryExpr) exprNode()   {}
+func (*ParenExpr) exprNode()    {}
+
+// Operand returns the name of the operand of the rule, with the selectors of a dotted operand
+// joined by ".". The operand of a function such as "any(tags)" is the name of its argument, and
+// the operand of an index expression such as "labels[`env`]" is the name of the map. An empty
+// string is returned when the operand is not made of identifiers.
+func (x *CmpExpr) Operand() string {
+	return OperandName(x.X)
+}
+
+// Func returns the name of the function applied to the operand of the rule, or an empty string
+// when there is none.
+func (x *CmpExpr) Func() string {
+	if c, ok := x.X.(*CallExpr); ok && c != nil && c.Fun != nil {
+		return c.Fun.Name
+	}
+	return ""
+}
+
+// OperandName returns the name of an operand expression, see CmpExpr.Operand.
+func OperandName(x Expr) string {
+	switch n := x.(type) {
+	case *Ident:
+		if n != nil {
+			return n.Name
+		}
+	case *CallExpr:
+		if n != nil {
+			switch n.Arg.(type) {
+			case *Ident, *SelectorExpr:
+				return OperandName(n.Arg)
+			}
+		}
+	case *IndexExpr:
+		if n != nil && n.Index != nil {
+			switch n.X.(type) {
+			case *Ident, *SelectorExpr, *IndexExpr:
+				return OperandName(n.X)
+			}
+		}
+	case *SelectorExpr:
+		if n != nil && n.Sel != nil && n.Sel.Name != "" {
+			switch n.X.(type) {
+			case *CallExpr, *IndexExpr:
+				// a function or an index is only applied to the whole operand
+				return ""
+			}
+			if prefix := OperandName(n.X); prefix != "" {
+				return prefix + "." + n.Sel.Name
+			}
+		}
+	}
+	return ""
+}
+
+// Rules returns the rules of the expression in the order they appear.
+func Rules(x Node) []*CmpExpr {
+	var rules []*CmpExpr
+	Inspect(x, func(n Node) bool {
+		if c, ok := n.(*CmpExpr); ok {
+			rules = append(rules, c)
+			return false
+		}
+		return true
+	})
+	return rules
+}
@@ -0,0 +1,164 @@
+package ast_test
+
+import (
+	"go/token"
+	"testing"
+
+	"github.com/kuangwanjing/ruleparser/ast"
+	"github.com/kuangwanjing/ruleparser/parser"
+)
+
+func TestFormat(t *testing.T) {
+	tables := []struct {
+		rules  string
+		format string
+	}{
+		{"a<1", "a < 1"},
+		{"a<-1.5;b in `x,y`", "a < -1.5; b in `x,y`"},
+		{"(a==true||b!=`c`)&&!c>=2", "(a == true || b != `c`) && !c >= 2"},
+		{"!(a<1);", "!(a < 1)"},
+		{"device.os.version>=`14`", "device.os.version >= `14`"},
+		{"a in (1,-2.5,3)&&b not_in[`x`]", "a in [1, -2.5, 3] && b not_in [`x`]"},
+		{"any(device.tags)==`x`||len(a)>1", "any(device.tags) == `x` || len(a) > 1"},
+		{"a.labels[`env`][1]==`x`&&!has_key(a.labels,`team`)", "a.labels[`env`][1] == `x` && !has_key(a.labels, `team`)"},
+		{"(coupon exists)&&referrer is nil||a!=nil", "(coupon exists) && referrer is nil || a != nil"},
+		{"a<1.2.3&&b>=v2.0.0-rc.1+build.5||c in(1.0.0,1.1.0)", "a < 1.2.3 && b >= v2.0.0-rc.1+build.5 || c in [1.0.0, 1.1.0]"},
+	}
+
+	for _, table := range tables {
+		x, err := parser.ParseRules(table.rules)
+		if err != nil {
+			t.Errorf("error happens when parsing `%s`: %v", table.rules, err)
+			continue
+		}
+		if f := ast.Format(x); f != table.format {
+			t.Errorf("`%s` should be formatted as `%s`, but `%s` is returned", table.rules, table.format, f)
+		}
+	}
+}
+
+// shape formats the logical structure of the expression, ignoring the parentheses.
+func shape(x ast.Expr) string {
+	switch n := x.(type) {
+	case *ast.ParenExpr:
+		return shape(n.X)
+	case *ast.UnaryExpr:
+		return n.Op.String() + "{" + shape(n.X) + "}"
+	case *ast.BinaryExpr:
+		return n.Op.String() + "{" + shape(n.X) + ", " + shape(n.Y) + "}"
+	}
+	return ast.Format(x)
+}
+
+func TestFormatBuiltTree(t *testing.T) {
+	leaf := func(name string) ast.Expr {
+		x, err := parser.ParseRules(name + " == 1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return x
+	}
+	bin := func(x ast.Expr, op token.Token, y ast.Expr) ast.Expr {
+		return &ast.BinaryExpr{X: x, Op: op, Y: y}
+	}
+	not := func(x ast.Expr) ast.Expr {
+		return &ast.UnaryExpr{Op: token.NOT, X: x}
+	}
+	a, b, c := leaf("a"), leaf("b"), leaf("c")
+
+	tables := []struct {
+		x      ast.Expr
+		format string
+	}{
+		{bin(bin(a, token.LOR, b), token.LAND, c), "(a == 1 || b == 1) && c == 1"},
+		{not(bin(a, token.LOR, b)), "!(a == 1 || b == 1)"},
+		{not(not(a)), "!!a == 1"},
+		{bin(bin(a, token.LOR, b), token.LOR, c), "a == 1 || b == 1 || c == 1"},
+		{bin(a, token.LOR, bin(b, token.LOR, c)), "a == 1 || (b == 1 || c == 1)"},
+		{bin(a, token.LOR, bin(b, token.LAND, c)), "a == 1 || b == 1 && c == 1"},
+		{bin(a, token.LAND, bin(b, token.SEMICOLON, c)), "a == 1 && (b == 1; c == 1)"},
+		{bin(bin(a, token.LOR, b), token.SEMICOLON, bin(c, token.LAND, not(a))), "a == 1 || b == 1; c == 1 && !a == 1"},
+	}
+
+	for _, table := range tables {
+		f := ast.Format(table.x)
+		if f != table.format {
+			t.Errorf("%s should be formatted as `%s`, but `%s` is returned", shape(table.x), table.format, f)
+			continue
+		}
+		// the formatted rules are parsed back into the same tree
+		x, err := parser.ParseRules(f)
+		if err != nil {
+			t.Errorf("error happens when parsing `%s`: %v", f, err)
+			continue
+		}
+		if shape(x) != shape(table.x) {
+			t.Errorf("`%s` should be parsed as %s, but %s is returned", f, shape(table.x), shape(x))
+		}
+	}
+}
+
+func TestPositions(t *testing.T) {
+	rules := "a < 1 || !(bb.c.d in `x,y`) && e in [1, 2.5] && all(f.g) > 1 && has_key(h[`i`], 2) && j[1] == 1 && k exists && l is nil && m < v1.2.3-rc.1"
+	x, err := parser.ParseRules(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every node covers exactly its own source, positions are offsets plus one
+	ast.Inspect(x, func(n ast.Node) bool {
+		if n == nil {
+			return false
+		}
+		src := rules[n.Pos()-1 : n.End()-1]
+		if src != ast.Format(n) {
+			t.Errorf("node %T covers `%s` instead of `%s`", n, src, ast.Format(n))
+		}
+		return true
+	})
+
+	b, ok := x.(*ast.BinaryExpr)
+	if !ok || b.Op != token.LOR || b.OpPos != 7 {
+		t.Fatalf("root should be `||` at 7, but %#v is found", x)
+	}
+}
+
+func TestRulesAndRewrite(t *testing.T) {
+	x, err := parser.ParseRules("plat == `ios` || plat == `ipados`; age > 18")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rules := ast.Rules(x)
+	if len(rules) != 3 || rules[0].Y.Value != "ios" || rules[1].Y.Value != "ipados" || rules[2].Operand() != "age" {
+		t.Fatalf("rules are not returned in order: %s", ast.Format(x))
+	}
+
+	// rename an operand, which is what a translator of the rules would do
+	ast.Inspect(x, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && id.Name == "plat" {
+			id.Name = "platform"
+		}
+		return true
+	})
+
+	p, err := parser.ParserFromExpr(x)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type TestContext struct {
+		Platform string `rule:"platform"`
+		Age      int    `rule:"age"`
+	}
+
+	rst, err := p.Examine(TestContext{"ipados", 20})
+	if err != nil || !rst {
+		t.Errorf("rewritten rules `%s` should pass, but (%v, %v) is returned", ast.Format(p.Expr()), rst, err)
+	}
+
+	rst, err = p.Examine(TestContext{"android", 20})
+	if err != nil || rst {
+		t.Errorf("rewritten rules `%s` should fail, but (%v, %v) is returned", ast.Format(p.Expr()), rst, err)
+	}
+}
@@ -0,0 +1,113 @@
+package ast
+
+import (
+	"go/token"
+	"strings"
+)
+
+// Format returns the source of the rules represented by the node. Strings are quoted by "`"
+// and the tokens are separated by a single space, so formatting the syntax tree of some rules
+// gives a canonical form of them. Parentheses are added where the precedence of the operators
+// requires them, so that a tree built by hand is parsed back into the same tree.
+func Format(node Node) string {
+	var b strings.Builder
+	format(&b, node)
+	return b.String()
+}
+
+func format(b *strings.Builder, node Node) {
+	switch n := node.(type) {
+	case *Ident:
+		b.WriteString(n.Name)
+	case *SelectorExpr:
+		format(b, n.X)
+		b.WriteString(".")
+		format(b, n.Sel)
+	case *IndexExpr:
+		format(b, n.X)
+		b.WriteString("[")
+		format(b, n.Index)
+		b.WriteString("]")
+	case *CallExpr:
+		format(b, n.Fun)
+		b.WriteString("(")
+		format(b, n.Arg)
+		b.WriteString(")")
+	case *BasicLit:
+		if n.Kind == List {
+			b.WriteString("[")
+			for i, elt := range n.Elts {
+				if i > 0 {
+					b.WriteString(", ")
+				}
+				format(b, elt)
+			}
+			b.WriteString("]")
+		} else if n.Kind == String {
+			b.WriteString("`" + n.Value + "`")
+		} else {
+			b.WriteString(n.Value)
+		}
+	case *CmpExpr:
+		if n.IsCall() {
+			b.WriteString(n.Op + "(")
+			format(b, n.X)
+			b.WriteString(", ")
+			format(b, n.Y)
+			b.WriteString(")")
+			break
+		}
+		format(b, n.X)
+		if n.Y == nil {
+			b.WriteString(" " + n.Op)
+			break
+		}
+		b.WriteString(" " + n.Op + " ")
+		format(b, n.Y)
+	case *UnaryExpr:
+		b.WriteString(n.Op.String())
+		formatOperand(b, n.X, precedence(n.Op), false)
+	case *BinaryExpr:
+		formatOperand(b, n.X, precedence(n.Op), false)
+		if n.Op == token.SEMICOLON {
+			b.WriteString("; ")
+		} else {
+			b.WriteString(" " + n.Op.String() + " ")
+		}
+		formatOperand(b, n.Y, precedence(n.Op), true)
+	case *ParenExpr:
+		b.WriteString("(")
+		format(b, n.X)
+		b.WriteString(")")
+	}
+}
+
+// formatOperand formats the operand of a logical operator of the precedence, which is enclosed
+// in parentheses when it binds looser than the operator. The right operand is enclosed as well
+// when it binds as tight, since the operators associate to the left.
+func formatOperand(b *strings.Builder, x Expr, prec int, right bool) {
+	if y, ok := x.(*BinaryExpr); ok {
+		if p := precedence(y.Op); p < prec || right && p == prec {
+			b.WriteString("(")
+			format(b, x)
+			b.WriteString(")")
+			return
+		}
+	}
+	format(b, x)
+}
+
+// precedence of the logical operators, in the same order as the parser binds them.
+func precedence(op token.Token) int {
+	switch op {
+	case token.NOT:
+		return 4
+	case token.LAND:
+		return 3
+	case token.LOR:
+		return 2
+	case token.SEMICOLON:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,69 @@
+package ast
+
+import (
+	"fmt"
+)
+
+// A Visitor's Visit method is invoked for each node encountered by Walk. If the result visitor
+// w is not nil, Walk visits each of the children of node with the visitor w, followed by a call
+// of w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses the syntax tree in depth-first order, in the same way as go/ast.Walk does.
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	switch n := node.(type) {
+	case *Ident:
+		// nothing to do
+	case *BasicLit:
+		for _, elt := range n.Elts {
+			Walk(v, elt)
+		}
+	case *SelectorExpr:
+		Walk(v, n.X)
+		Walk(v, n.Sel)
+	case *IndexExpr:
+		Walk(v, n.X)
+		Walk(v, n.Index)
+	case *CallExpr:
+		Walk(v, n.Fun)
+		Walk(v, n.Arg)
+	case *CmpExpr:
+		Walk(v, n.X)
+		if n.Y != nil {
+			Walk(v, n.Y)
+		}
+	case *UnaryExpr:
+		Walk(v, n.X)
+	case *BinaryExpr:
+		Walk(v, n.X)
+		Walk(v, n.Y)
+	case *ParenExpr:
+		Walk(v, n.X)
+	default:
+		panic(fmt.Sprintf("ast.Walk: unexpected node type %T", n))
+	}
+
+	v.Visit(nil)
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses the syntax tree in depth-first order. It starts by calling f(node); if f
+// returns true, Inspect invokes f recursively for each of the children of node, followed by a
+// call of f(nil).
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
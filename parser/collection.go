@@ -0,0 +1,133 @@
+package parser
+
+import (
+	"context"
+	"github.com/kuangwanjing/ruleparser/ast"
+	"reflect"
+)
+
+var interfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+
+// isCollection tells whether the values of the kind hold elements examined by any and all.
+func isCollection(k reflect.Kind) bool {
+	return k == reflect.Slice || k == reflect.Array
+}
+
+// hasLen tells whether the values of the kind have a length for len.
+func hasLen(k reflect.Kind) bool {
+	return isCollection(k) || k == reflect.Map || k == reflect.String
+}
+
+// equalRule returns the rule testing the equality with the value of the rule, which is how a
+// collection contains the value.
+func equalRule(rule *ast.CmpExpr) *ast.CmpExpr {
+	return &ast.CmpExpr{X: rule.X, OpPos: rule.OpPos, Op: "==", Y: rule.Y}
+}
+
+// collectionValue returns the value the pointers and interfaces hold, which must be of a kind
+// accepted by the function of the rule.
+func collectionValue(rule *ast.CmpExpr, name string, v reflect.Value, accept func(reflect.Kind) bool) (reflect.Value, error) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return v, nilError(v.Kind().String(), rule)
+		}
+		v = v.Elem()
+	}
+	if !accept(v.Kind()) {
+		return v, typeError(rule, name+" is not available for "+v.Kind().String()+" value "+rule.Operand())
+	}
+	return v, nil
+}
+
+// compileCall returns the function examining a field of type t with the function of the given
+// name applied. any and all examine the elements of a slice or an array with the function elem
+// compiles for the type of the elements: any passes when some element passes and all passes when
+// no element fails, so all passes for an empty collection while any fails. len compares the
+// length of a slice, an array, a map or a string.
+func (p *RuleParser) compileCall(rule *ast.CmpExpr, name string, t reflect.Type,
+	elem func(reflect.Type) (func(context.Context, reflect.Value) (bool, error), bool, error)) (
+	func(context.Context, reflect.Value) (bool, error), bool, error) {
+	ct := elemType(t)
+	dynamic := ct.Kind() == reflect.Interface
+
+	if name == "len" {
+		if !dynamic && !hasLen(ct.Kind()) {
+			return nil, false, typeError(rule, "len is not available for "+ct.Kind().String()+" field "+rule.Operand())
+		}
+		fn, _, err := p.compileRule(rule, intType)
+		if err != nil {
+			return nil, false, err
+		}
+		return func(ctx context.Context, v reflect.Value) (bool, error) {
+			v, err := collectionValue(rule, name, v, hasLen)
+			if err != nil {
+				return false, err
+			}
+			return fn(ctx, reflect.ValueOf(v.Len()))
+		}, true, nil
+	}
+
+	if !dynamic && !isCollection(ct.Kind()) {
+		return nil, false, typeError(rule, name+" is not available for "+ct.Kind().String()+" field "+rule.Operand())
+	}
+
+	et := interfaceType
+	if !dynamic {
+		et = ct.Elem()
+	}
+	fn, builtin, err := elem(et)
+	if err != nil {
+		return nil, false, err
+	}
+
+	all := name == "all"
+	return func(ctx context.Context, v reflect.Value) (bool, error) {
+		v, err := collectionValue(rule, name, v, isCollection)
+		if err != nil {
+			return false, err
+		}
+		for i := 0; i < v.Len(); i++ {
+			ok, err := fn(ctx, v.Index(i))
+			if err != nil {
+				return false, err
+			}
+			if ok != all {
+				// the first passing element decides any, and the first failing one decides all
+				return ok, nil
+			}
+		}
+		return all, nil
+	}, builtin, nil
+}
+
+// compileDynamicContains returns the function examining a value, whose type is only known when
+// the context is examined, with contains: a string contains the string of the rule and a slice or
+// an array contains an element equal to the value of the rule.
+func (p *RuleParser) compileDynamicContains(rule *ast.CmpExpr) (func(context.Context, reflect.Value) (bool, error), error) {
+	str, strErr := compileDynamicString(rule)
+	eq, err := p.compileDynamic(equalRule(rule))
+	if err != nil {
+		return nil, err
+	}
+
+	return func(ctx context.Context, v reflect.Value) (bool, error) {
+		v, err := collectionValue(rule, rule.Op, v, func(k reflect.Kind) bool {
+			return k == reflect.String || isCollection(k)
+		})
+		if err != nil {
+			return false, err
+		}
+		if v.Kind() == reflect.String {
+			if strErr != nil {
+				return false, strErr
+			}
+			return str(ctx, v)
+		}
+		for i := 0; i < v.Len(); i++ {
+			if ok, err := eq(ctx, v.Index(i)); ok || err != nil {
+				return ok, err
+			}
+		}
+		return false, nil
+	}, nil
+}
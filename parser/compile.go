@@ -0,0 +1,641 @@
+package parser
+
+import (
+	"context"
+	"github.com/kuangwanjing/ruleparser/ast"
+	"reflect"
+	"strings"
+)
+
+// Evaluator examines contexts of a single struct or map type with the rules of a parser. The
+// struct tags, field indices, operation methods and literals are resolved once when the evaluator
+// is compiled, so that examining a context does not need to look them up again.
+type Evaluator struct {
+	p       *RuleParser
+	typ     reflect.Type
+	rules   []*compiledRule // by the index of the rule, nil for a rule not bound to any field
+	count   int             // number of the bound rules
+	methods int             // number of the bound rules calling a method of the field
+	unbound []string        // operands not bound to any field, in the order they appear
+}
+
+// compiledRule is a rule bound to a field of the context.
+type compiledRule struct {
+	rule    *ast.CmpExpr
+	builtin bool                                                     // compared by the parser without calling any method
+	field   []int                                                    // index sequence of the field, see fieldValue, nil for a map
+	examine func(ctx context.Context, v reflect.Value) (bool, error) // examines the value of the field
+}
+
+type compiledPlan struct {
+	e   *Evaluator
+	err error
+}
+
+// Compile binds the rules to the fields of the struct type, or of the struct a pointer type
+// points to, and returns the evaluator for the contexts of the type. Rules whose operand is not
+// bound to any field are not examined and pass, unless the parser is strict. An error is
+// returned when a rule can not be examined against the field it is bound to. A map type keyed by
+// string is bound to the entries keyed by the operands instead, see RegisterOperator.
+func (p *RuleParser) Compile(t reflect.Type) (*Evaluator, error) {
+	e, err := p.compile(t)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.strict {
+		if err := e.checkOperands(); err != nil {
+			return nil, err
+		}
+	}
+
+	return e, nil
+}
+
+func (p *RuleParser) compile(t reflect.Type) (*Evaluator, error) {
+	e, errs := p.bind(t)
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return e, nil
+}
+
+// bind binds the rules to the fields of the struct type and returns the evaluator together with
+// every error met, so that all the problems of the rules can be reported at once.
+func (p *RuleParser) bind(t reflect.Type) (*Evaluator, []error) {
+	if t == nil {
+		return nil, []error{&ContextError{Msg: "nil is not accepted"}}
+	}
+	t = elemType(t)
+
+	if t.Kind() == reflect.Map {
+		return p.bindMap(t)
+	}
+
+	// since the evaluator handles struct and map only, any other kind of context is rejected.
+	if t.Kind() != reflect.Struct {
+		return nil, []error{&ContextError{Type: t, Msg: t.Kind().String() + " is not accepted"}}
+	}
+
+	var errs []error
+	bound := make([]bool, p.ruleCount)
+	e := &Evaluator{p: p, typ: t, rules: make([]*compiledRule, p.ruleCount)}
+	for idx, rule := range p.rules {
+		field, ok := fieldByOperand(t, rule.Operand())
+		if !ok {
+			continue
+		}
+		bound[idx] = true
+		examine, builtin, err := p.compileField(rule, field)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		e.rules[idx] = &compiledRule{rule, builtin, field.Index, p.guard(rule, examine)}
+		e.count += 1
+		if !builtin {
+			e.methods += 1
+		}
+	}
+
+	for i := range e.rules {
+		if !bound[i] && !containsString(e.unbound, p.rules[i].Operand()) {
+			e.unbound = append(e.unbound, p.rules[i].Operand())
+		}
+	}
+
+	return e, errs
+}
+
+// ValidationError lists every problem of the rules found by Validate.
+type ValidationError struct {
+	Type   reflect.Type // type of context the rules are validated against
+	Errors []error      // problems in the order of the rules
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	name := "<nil>"
+	if e.Type != nil {
+		name = e.Type.String()
+	}
+	return "rules are not valid for " + name + ": " + strings.Join(msgs, "; ")
+}
+
+func (e *ValidationError) Unwrap() []error {
+	return e.Errors
+}
+
+// Validate checks every rule of the parser against the struct or map type without any value of it:
+// the operand must be bound to a field, the operation must be available for the kind of the
+// field, the operation method must exist with the signature func(string) (int, error) and the
+// value must match the field. A *ValidationError listing all the problems is returned, so rules
+// loaded from a configuration service can be rejected before they are ever examined.
+func (p *RuleParser) Validate(t reflect.Type) error {
+	e, errs := p.bind(t)
+	if e != nil && len(e.unbound) > 0 {
+		errs = append([]error{e.checkOperands()}, errs...)
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return &ValidationError{elemType(t), errs}
+}
+
+// Unbound returns the operands of the rules which are not bound to any field of the type.
+func (e *Evaluator) Unbound() []string {
+	return append([]string(nil), e.unbound...)
+}
+
+func (e *Evaluator) checkOperands() error {
+	if len(e.unbound) == 0 {
+		return nil
+	}
+	return &OperandError{Type: e.typ, Operands: append([]string(nil), e.unbound...)}
+}
+
+// CheckOperands reports an error naming the operands of the rules which are not bound to any
+// field of the struct type. Such rules would otherwise be skipped silently by Examine.
+func (p *RuleParser) CheckOperands(t reflect.Type) error {
+	e, err := p.evaluator(elemType(t))
+	if err != nil {
+		return err
+	}
+	return e.checkOperands()
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluator returns the cached evaluator for the type of context and compiles it at the first time.
+func (p *RuleParser) evaluator(t reflect.Type) (*Evaluator, error) {
+	if plan, ok := p.plans.Load(t); ok {
+		return plan.(*compiledPlan).e, plan.(*compiledPlan).err
+	}
+	e, err := p.compile(t)
+	plan, _ := p.plans.LoadOrStore(t, &compiledPlan{e, err})
+	return plan.(*compiledPlan).e, plan.(*compiledPlan).err
+}
+
+// Type returns the struct or map type the evaluator is compiled for.
+func (e *Evaluator) Type() reflect.Type {
+	return e.typ
+}
+
+// Examine examines the context, which must be of the type the evaluator is compiled for or a
+// pointer to it.
+func (e *Evaluator) Examine(obj interface{}) (bool, error) {
+	return e.ExamineContext(context.Background(), obj)
+}
+
+// ExamineContext is the counterpart of RuleParser.ExamineContext for the type the evaluator is
+// compiled for.
+func (e *Evaluator) ExamineContext(ctx context.Context, obj interface{}) (bool, error) {
+	val, err := e.value(obj)
+	if err != nil {
+		return false, err
+	}
+	return e.examine(ctx, val)
+}
+
+func (e *Evaluator) value(obj interface{}) (reflect.Value, error) {
+	val, err := contextValue(obj)
+	if err != nil {
+		return val, err
+	}
+	if val.Type() != e.typ {
+		return val, &ContextError{Type: val.Type(), Msg: val.Type().String() + " is not accepted by the evaluator of " +
+			e.typ.String()}
+	}
+	return val, nil
+}
+
+// ExamineContext examines the object like Examine does, and stops the examination with the error
+// of ctx as soon as ctx is cancelled or its deadline is exceeded. The timeout of the parser still
+// applies. ctx is handed to the operation methods accepting a context.Context as their first
+// parameter, and it is cancelled once the examination is over, so that the remote calls made by
+// the methods which are no longer needed can give up.
+func (p *RuleParser) ExamineContext(ctx context.Context, obj interface{}) (bool, error) {
+	val, err := contextValue(obj)
+	if err != nil {
+		return false, err
+	}
+
+	e, err := p.evaluator(val.Type())
+	if err != nil {
+		return false, err
+	}
+
+	return e.examine(ctx, val)
+}
+
+// withTimeout applies the timeout of the parser to ctx.
+func (p *RuleParser) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithTimeout(ctx, p.timeout)
+}
+
+// doneError returns the error of an examination whose context is done. The cancellation of the
+// caller is reported as it is, otherwise the timeout of the parser is exceeded.
+func doneError(parent context.Context) error {
+	if parent != nil && parent.Err() != nil {
+		return parent.Err()
+	}
+	return ErrTimeout
+}
+
+func (e *Evaluator) examine(parent context.Context, val reflect.Value) (bool, error) {
+	p := e.p
+
+	// the strictness is checked on every examination since the cached evaluator is shared by
+	// both modes.
+	if p.strict {
+		if err := e.checkOperands(); err != nil {
+			return false, err
+		}
+		if val.Kind() == reflect.Map {
+			if err := e.checkKeys(val); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	// rules whose operand is not bound to any field of the context are not examined and pass.
+	o := newOutcomes(p.ruleCount)
+	for i := range e.rules {
+		if e.skipped(i, val) {
+			o.skipped(i)
+		}
+	}
+
+	// the examination stops as soon as the result of the whole expression is decided. A rule
+	// failing with an error fails the examination only when the result depends on it, so that
+	// every strategy returns the same result whatever the order the rules finish in.
+	if v, ok, _ := p.expr.resolve(o, false); ok {
+		return v, nil
+	}
+
+	if parent != nil && parent.Err() != nil {
+		return false, parent.Err()
+	}
+
+	if parent == nil {
+		parent = context.Background()
+	}
+
+	if p.strategy == Sequential {
+		// the timeout only matters to the methods, which are the only rules able to block
+		ctx := parent
+		if e.methods > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = p.withTimeout(parent)
+			defer cancel()
+		}
+		e.sequential(ctx, parent, p.expr, val, o, false)
+		return o.result(p.expr)
+	}
+
+	// the rules examined inline go first, since their results may decide the whole expression
+	// without starting any goroutine. They are compared by the parser and never block.
+	var async []int
+	for i, rule := range e.rules {
+		if o.done[i] {
+			continue
+		}
+		if !e.inline(rule) {
+			async = append(async, i)
+			continue
+		}
+		v, err := rule.eval(parent, val)
+		o.record(i, v, err)
+		if v, ok, _ := p.expr.resolve(o, false); ok {
+			return v, nil
+		}
+	}
+
+	ctx, cancel := p.withTimeout(parent)
+	defer cancel()
+
+	// the channel is buffered for every rule, so the rules which are still running when the
+	// examination returns early don't block on sending their results and always terminate,
+	// given their methods honor the cancellation of ctx.
+	ch := make(chan RuleParserChannel, len(async))
+	e.dispatch(ctx, async, func(i int) {
+		e.rules[i].run(ctx, i, val, ch)
+	})
+
+	for range async {
+		select {
+		case ret := <-ch:
+			o.record(ret.rule, ret.rst, ruleError(ctx, parent, ret.err))
+			if v, ok, _ := p.expr.resolve(o, false); ok {
+				return v, nil
+			}
+		case <-ctx.Done():
+			// the results sent before ctx is done may still decide the expression, while the
+			// rules not finished yet fail with the error of ctx.
+			o.drain(ctx, parent, ch)
+			for _, i := range async {
+				if !o.done[i] {
+					o.record(i, false, doneError(parent))
+				}
+			}
+			return o.result(p.expr)
+		}
+	}
+
+	return o.result(p.expr)
+}
+
+// drain records the results waiting in ch without blocking.
+func (o *outcomes) drain(ctx, parent context.Context, ch chan RuleParserChannel) {
+	for {
+		select {
+		case ret := <-ch:
+			o.record(ret.rule, ret.rst, ruleError(ctx, parent, ret.err))
+		default:
+			return
+		}
+	}
+}
+
+// ruleError returns the error of a rule examined with ctx. A rule failing once ctx is done fails
+// with the error of the examination as a whole, see doneError.
+func ruleError(ctx, parent context.Context, err error) error {
+	if err != nil && err != errSkip && ctx.Err() != nil {
+		return doneError(parent)
+	}
+	return err
+}
+
+func (r *compiledRule) run(ctx context.Context, idx int, val reflect.Value, ch chan RuleParserChannel) {
+	rst, err := r.eval(ctx, val)
+	ch <- RuleParserChannel{idx, rst, err}
+}
+
+// skipped tells whether the rule is not examined and passes, since its operand is not bound to
+// any field of the context or, for a map, is not found in it. The presence of an entry of a map
+// is examined even if it is not found.
+func (e *Evaluator) skipped(i int, val reflect.Value) bool {
+	if e.rules[i] == nil {
+		return true
+	}
+	if val.Kind() == reflect.Map && !isPresenceRule(e.p.rules[i]) {
+		_, ok := mapValue(val, e.p.rules[i].Operand())
+		return !ok
+	}
+	return false
+}
+
+// eval examines the field of the context the rule is bound to.
+func (r *compiledRule) eval(ctx context.Context, val reflect.Value) (bool, error) {
+	return r.examine(ctx, r.value(val))
+}
+
+// value returns the field, or the entry of a map, of the context the rule is bound to. The
+// invalid value is returned when a nil pointer is met on the way to the field or the entry is
+// not found, see guardNil.
+func (r *compiledRule) value(val reflect.Value) reflect.Value {
+	if val.Kind() == reflect.Map {
+		fv, _ := mapValue(val, r.rule.Operand())
+		return fv
+	}
+	fv, _ := fieldValue(val, r.field)
+	return fv
+}
+
+// elemType returns the type a pointer type points to.
+func elemType(t reflect.Type) reflect.Type {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// contextValue retrieves the struct or the map the context holds or points to.
+func contextValue(obj interface{}) (reflect.Value, error) {
+	val := reflect.ValueOf(obj)
+	for val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface {
+		if val.IsNil() {
+			return val, &ContextError{Type: val.Type(), Msg: "nil " + val.Kind().String() + " is not accepted"}
+		}
+		val = val.Elem()
+	}
+
+	if val.Kind() != reflect.Struct && val.Kind() != reflect.Map {
+		if !val.IsValid() {
+			return val, &ContextError{Msg: "nil is not accepted"}
+		}
+		return val, &ContextError{Type: val.Type(), Msg: val.Kind().String() + " is not accepted"}
+	}
+
+	return val, nil
+}
+
+// compileField returns the function examining the field with the rule according to the options
+// of its struct tag.
+func (p *RuleParser) compileField(rule *ast.CmpExpr, field reflect.StructField) (func(context.Context, reflect.Value) (bool, error), bool, error) {
+	semver := hasOption(field, "semver")
+	elem := func(t reflect.Type) (func(context.Context, reflect.Value) (bool, error), bool, error) {
+		if isPresenceRule(rule) {
+			return compilePresence(rule), true, nil
+		}
+		if semver {
+			fn, err := compileSemver(rule, t)
+			return fn, true, err
+		}
+		return p.compileValue(rule, t)
+	}
+
+	if name := rule.Func(); name != "" && isPresenceRule(rule) {
+		return nil, false, typeError(rule, rule.Op+" is not available for "+name+"("+rule.Operand()+")")
+	} else if name != "" {
+		return p.compileCall(rule, name, field.Type, elem)
+	}
+	if keys := indexKeys(rule.X); len(keys) > 0 {
+		return p.compileIndex(rule, keys, field.Type, elem)
+	}
+	return elem(field.Type)
+}
+
+// compileRule returns the function examining the value of a field of type t with the rule, and
+// whether the rule is compared by the parser itself rather than by a method of the field or an
+// operator. The method of the field goes before the registered operators, which go before the
+// built-in operations other than the basic comparisons.
+func (p *RuleParser) compileRule(rule *ast.CmpExpr, t reflect.Type) (func(context.Context, reflect.Value) (bool, error), bool, error) {
+	et := t
+	for et.Kind() == reflect.Ptr {
+		et = et.Elem()
+	}
+
+	if isBasicOperation(rule.Op) && (et == timeType || et == durationType) {
+		fn, err := p.compileTime(rule, t)
+		return fn, true, err
+	}
+
+	if !isBasicOperation(rule.Op) {
+		if _, ok := t.MethodByName(ConvertOperationName(rule.Op)); ok {
+			// even a field of basic data type may carry the method of the operation
+			fn, err := compileMethod(rule, t)
+			return fn, false, err
+		}
+		if fn := p.compileOperator(rule, t); fn != nil {
+			return fn, false, nil
+		}
+		if fn := p.compileMatch(rule, t); fn != nil {
+			return fn, true, nil
+		}
+		if fn, err := compileString(rule, t); fn != nil || err != nil {
+			return fn, true, err
+		}
+		if rule.Op == "contains" && isCollection(et.Kind()) {
+			eq := equalRule(rule)
+			return p.compileCall(eq, "any", t, func(t reflect.Type) (func(context.Context, reflect.Value) (bool, error), bool, error) {
+				return p.compileValue(eq, t)
+			})
+		}
+		if rule.Op == "has_key" && et.Kind() == reflect.Map {
+			fn, err := compileHasKey(rule, t)
+			return fn, true, err
+		}
+	}
+
+	if !isBasicDataType(et.Kind().String()) {
+		fn, err := compileMethod(rule, t)
+		return fn, false, err
+	}
+
+	fn, err := compileBasic(rule, et)
+	return fn, true, err
+}
+
+// compileBasic returns the function comparing a field of basic data type with the literal.
+func compileBasic(rule *ast.CmpExpr, et reflect.Type) (func(context.Context, reflect.Value) (bool, error), error) {
+	k := et.Kind().String()
+	if isSetOperation(rule.Op) && !isUncomparableDataType(k) {
+		return compileSet(rule, et)
+	}
+	if !isBasicOperation(rule.Op) || isUncomparableDataType(k) {
+		return nil, typeError(rule, rule.Op+" is not available for "+rule.Operand())
+	}
+
+	if rule.Y.Kind == ast.Version && et.Kind() == reflect.String && isBasicOperation(rule.Op) {
+		return compileSemver(rule, et)
+	}
+
+	// the kind of the literal is known once the field is bound, so a mismatch is reported
+	// without comparing anything.
+	if !literalMatches(et.Kind(), rule.Y) {
+		return nil, typeError(rule, rule.Y.Kind.String()+" literal "+rule.Y.Value+" does not match "+
+			k+" field "+rule.Operand())
+	}
+
+	cmp := compileCmp(et.Kind(), rule.Y)
+	op := GetBasicOperation(rule.Op)
+	return func(_ context.Context, v reflect.Value) (bool, error) {
+		for v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return false, nilError("pointer", rule)
+			}
+			v = v.Elem()
+		}
+		return op(cmp(v)), nil
+	}, nil
+}
+
+// compileCmp returns the comparison between a value of the kind and the literal, which is
+// specialized once for the kind so that no type switch is made on every comparison.
+func compileCmp(k reflect.Kind, lit *ast.BasicLit) func(reflect.Value) int {
+	switch k {
+	case reflect.String:
+		s := lit.Val.(string)
+		return func(v reflect.Value) int {
+			return strings.Compare(v.String(), s)
+		}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int, reflect.Int64:
+		if i, ok := lit.Val.(int64); ok {
+			return func(v reflect.Value) int {
+				return cmpInt64(v.Int(), i)
+			}
+		}
+	case reflect.Float32, reflect.Float64:
+		f := literalFloat(lit)
+		return func(v reflect.Value) int {
+			return cmpFloat64(v.Float(), f)
+		}
+	}
+
+	// the remaining cases are rare enough to go through the generic comparison
+	return func(v reflect.Value) int {
+		ret, _ := cmpLiteral(v, lit)
+		return ret
+	}
+}
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	stringType  = reflect.TypeOf("")
+	intType     = reflect.TypeOf(0)
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// compileMethod returns the function calling the operation method of the field type. Basic
+// operations call the "Cmp" method and any other operation calls the method named after it.
+// The method may accept a context.Context before the value of the rule.
+func compileMethod(rule *ast.CmpExpr, t reflect.Type) (func(context.Context, reflect.Value) (bool, error), error) {
+	var fnName = ""
+	if isBasicOperation(rule.Op) {
+		fnName = "Cmp"
+	} else {
+		// convert the first letter into upper case, so that the call is made towards an accessible method
+		fnName = ConvertOperationName(rule.Op)
+	}
+
+	method, ok := t.MethodByName(fnName)
+	if !ok {
+		return nil, &MethodError{Operand: rule.Operand(), Method: fnName, Type: t}
+	}
+
+	// the method is called with the receiver as its first argument
+	mt := method.Type
+	withContext := mt.NumIn() == 3 && mt.In(1) == contextType
+	if mt.NumIn() != 2 && !withContext || mt.In(mt.NumIn()-1) != stringType || mt.NumOut() != 2 ||
+		mt.Out(0) != intType || mt.Out(1) != errorType {
+		return nil, &MethodError{Operand: rule.Operand(), Method: fnName, Type: t, Found: true}
+	}
+
+	fn := method.Func
+	arg := reflect.ValueOf(rule.Y.Value)
+	cmp := fnName == "Cmp"
+	op := GetBasicOperation(rule.Op)
+	return func(ctx context.Context, v reflect.Value) (bool, error) {
+		if v.Kind() == reflect.Ptr && v.IsNil() {
+			return false, nilError("pointer", rule)
+		}
+		in := []reflect.Value{v, arg}
+		if withContext {
+			in = []reflect.Value{v, reflect.ValueOf(&ctx).Elem(), arg}
+		}
+		ret := fn.Call(in)
+		if err, _ := ret[1].Interface().(error); err != nil {
+			return false, err
+		}
+		retInt := int(ret[0].Int())
+		if cmp {
+			// basic comparison is built in the package
+			return op(retInt), nil
+		}
+		return retInt == 0, nil
+	}, nil
+}
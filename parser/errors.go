@@ -0,0 +1,161 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"github.com/kuangwanjing/ruleparser/ast"
+	"go/token"
+	"reflect"
+	"strings"
+)
+
+// The kinds of the errors returned by the parser, which are matched by errors.Is. The errors
+// carrying the details of a kind are matched by errors.As with the types below.
+var (
+	ErrSyntax             = errors.New("syntax error")         // see SyntaxError
+	ErrUnknownOperand     = errors.New("unknown operand")      // see OperandError
+	ErrMissingMethod      = errors.New("missing method")       // see MethodError
+	ErrTypeMismatch       = errors.New("type mismatch")        // see TypeError
+	ErrUnsupportedContext = errors.New("unsupported context")  // see ContextError
+	ErrNilValue           = errors.New("nil value")            // see NilValueError
+	ErrTimeout            = errors.New("timeout when parsing") // the timeout of the parser is exceeded
+)
+
+// SyntaxError reports rules, or a syntax tree of them, which can not be parsed.
+type SyntaxError struct {
+	Pos token.Pos // position of the error, see package ast, or token.NoPos when it is unknown
+	Msg string    // description of the error
+	Err error     // underlying cause, such as an invalid literal or regular expression, or nil
+}
+
+func (e *SyntaxError) Error() string {
+	return e.Msg
+}
+
+func (e *SyntaxError) Is(target error) bool {
+	return target == ErrSyntax
+}
+
+func (e *SyntaxError) Unwrap() error {
+	return e.Err
+}
+
+func syntaxError(pos token.Pos, format string, args ...interface{}) error {
+	return &SyntaxError{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// causedSyntaxError is syntaxError keeping the error causing it.
+func causedSyntaxError(pos token.Pos, err error, format string, args ...interface{}) error {
+	return &SyntaxError{pos, fmt.Sprintf(format, args...), err}
+}
+
+// OperandError reports the operands of the rules which are not bound to any field of the
+// context, or not found in a map context, when the parser is strict, or the key of a map field
+// which is not found.
+type OperandError struct {
+	Type     reflect.Type  // type of the context, nil for a key not found
+	Operands []string      // operands in the order they appear
+	Key      *ast.BasicLit // key not found in the map field of the only operand, or nil
+}
+
+func (e *OperandError) Error() string {
+	if e.Key != nil {
+		return "key " + ast.Format(e.Key) + " is not found in " + strings.Join(e.Operands, ", ")
+	}
+	name := "<nil>"
+	if e.Type != nil {
+		name = e.Type.String()
+	}
+	if e.Type != nil && e.Type.Kind() == reflect.Map {
+		return "operands " + strings.Join(e.Operands, ", ") + " are not found in " + name
+	}
+	return "operands " + strings.Join(e.Operands, ", ") + " are not bound to any field of " + name
+}
+
+func (e *OperandError) Is(target error) bool {
+	return target == ErrUnknownOperand
+}
+
+// MethodError reports the operation method of a field which is not found, or is found with a
+// signature other than func([context.Context,] string) (int, error).
+type MethodError struct {
+	Operand string       // operand of the rule
+	Method  string       // name of the method, such as "Cmp" or "In"
+	Type    reflect.Type // type of the field
+	Found   bool         // whether the method is found with another signature
+}
+
+func (e *MethodError) Error() string {
+	if e.Found {
+		return e.Method + " function of " + e.Operand +
+			" should accept an optional context.Context and a string and return an integer and an error object"
+	}
+	return e.Method + " function is not found for " + e.Operand
+}
+
+func (e *MethodError) Is(target error) bool {
+	return target == ErrMissingMethod
+}
+
+// TypeError reports a rule which can not be examined against the type of the value of its
+// operand, such as a literal of another kind or an operation not available for the type.
+type TypeError struct {
+	Operand string // operand of the rule
+	Msg     string // description of the error
+	Err     error  // underlying cause, such as an invalid literal, or nil
+}
+
+func (e *TypeError) Error() string {
+	return e.Msg
+}
+
+func (e *TypeError) Is(target error) bool {
+	return target == ErrTypeMismatch
+}
+
+func (e *TypeError) Unwrap() error {
+	return e.Err
+}
+
+func typeError(rule *ast.CmpExpr, msg string) error {
+	return &TypeError{Operand: rule.Operand(), Msg: msg}
+}
+
+// ContextError reports a context which is neither a struct nor a map keyed by string, nor a
+// pointer to them.
+type ContextError struct {
+	Type reflect.Type // type of the context, nil for nil or a JSON document
+	Msg  string       // description of the error
+	Err  error        // underlying cause, such as an invalid JSON document, or nil
+}
+
+func (e *ContextError) Error() string {
+	return e.Msg
+}
+
+func (e *ContextError) Is(target error) bool {
+	return target == ErrUnsupportedContext
+}
+
+func (e *ContextError) Unwrap() error {
+	return e.Err
+}
+
+// NilValueError reports a nil value met when a rule is examined with the NilError policy,
+// either on the way to the value of its operand or as the value itself.
+type NilValueError struct {
+	Kind    string // kind of the nil value, such as "pointer"
+	Operand string // operand of the rule
+}
+
+func (e *NilValueError) Error() string {
+	return "nil " + e.Kind + " is met for " + e.Operand
+}
+
+func (e *NilValueError) Is(target error) bool {
+	return target == ErrNilValue
+}
+
+func nilError(kind string, rule *ast.CmpExpr) error {
+	return &NilValueError{kind, rule.Operand()}
+}
@@ -0,0 +1,207 @@
+package parser
+
+import (
+	"context"
+	"fmt"
+	"github.com/kuangwanjing/ruleparser/ast"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// RuleResult is the outcome of a single rule reported by Explain.
+type RuleResult struct {
+	Rule      *ast.CmpExpr  // the rule in the syntax tree
+	Operand   string        // operand of the rule
+	Operation string        // operation of the rule
+	Value     string        // value of the rule as written, empty for an operation taking no value
+	Bound     bool          // whether the operand is bound to a field of the context
+	Actual    interface{}   // value of the field, nil when it is not bound or not exported
+	Result    bool          // outcome of the rule, true for a rule which is not bound
+	Err       error         // error met when examining the rule
+	Duration  time.Duration // time spent on examining the rule
+}
+
+// Explanation is the detailed result of examining a context with the rules.
+type Explanation struct {
+	Result   bool          // result of the whole rules, as returned by Examine
+	Err      error         // error the result depends on, as returned by Examine
+	Rules    []RuleResult  // outcome of each rule, in the order they appear
+	Duration time.Duration // time spent on examining all the rules
+}
+
+// String formats the explanation with one line for each rule.
+func (x *Explanation) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "result: %v", x.Result)
+	if x.Err != nil {
+		fmt.Fprintf(&b, ", error: %v", x.Err)
+	}
+	fmt.Fprintf(&b, " (%v)", x.Duration)
+	for _, r := range x.Rules {
+		fmt.Fprintf(&b, "\n%s: ", ast.Format(r.Rule))
+		if !r.Bound {
+			b.WriteString("not bound")
+			continue
+		}
+		fmt.Fprintf(&b, "%v, actual: %v", r.Result, r.Actual)
+		if r.Err != nil {
+			fmt.Fprintf(&b, ", error: %v", r.Err)
+		}
+		fmt.Fprintf(&b, " (%v)", r.Duration)
+	}
+	return b.String()
+}
+
+// Explain examines the context like Examine does, but examines every rule without stopping at
+// the first decisive one, even with the Sequential strategy, and reports the outcome of each of
+// them. Errors about the context itself, such as a context which is not a struct, are returned
+// as the error while the errors of the rules are reported in the explanation. The result and its
+// error are resolved from the outcomes of the rules in the same way as Examine does.
+func (p *RuleParser) Explain(obj interface{}) (*Explanation, error) {
+	return p.ExplainContext(context.Background(), obj)
+}
+
+// ExplainContext is Explain honoring the cancellation and the deadline of ctx in the same way
+// as ExamineContext does. The rules which are not finished in time report the error of ctx.
+func (p *RuleParser) ExplainContext(ctx context.Context, obj interface{}) (*Explanation, error) {
+	val, err := contextValue(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := p.evaluator(val.Type())
+	if err != nil {
+		return nil, err
+	}
+
+	return e.explain(ctx, val), nil
+}
+
+// Explain is the counterpart of RuleParser.Explain for the type the evaluator is compiled for.
+func (e *Evaluator) Explain(obj interface{}) (*Explanation, error) {
+	return e.ExplainContext(context.Background(), obj)
+}
+
+// ExplainContext is the counterpart of RuleParser.ExplainContext for the type the evaluator is
+// compiled for.
+func (e *Evaluator) ExplainContext(ctx context.Context, obj interface{}) (*Explanation, error) {
+	val, err := e.value(obj)
+	if err != nil {
+		return nil, err
+	}
+	return e.explain(ctx, val), nil
+}
+
+type explainChannel struct {
+	rule     int
+	actual   interface{}
+	rst      bool
+	err      error
+	duration time.Duration
+}
+
+func (e *Evaluator) explain(parent context.Context, val reflect.Value) *Explanation {
+	p := e.p
+	start := time.Now()
+
+	ctx, cancel := p.withTimeout(parent)
+	defer cancel()
+
+	x := &Explanation{Rules: make([]RuleResult, p.ruleCount)}
+	o := newOutcomes(p.ruleCount)
+	for i, rule := range p.rules {
+		if e.skipped(i, val) {
+			o.skipped(i)
+		}
+		var value string
+		if rule.Y != nil {
+			value = rule.Y.Value
+		}
+		x.Rules[i] = RuleResult{
+			Rule:      rule,
+			Operand:   rule.Operand(),
+			Operation: rule.Op,
+			Value:     value,
+			Bound:     !o.skip[i],
+			Result:    o.skip[i],
+		}
+	}
+
+	// the rules examined inline are recorded as they finish, and like Examine does, the rules
+	// calling a method fail without being examined once ctx is done.
+	var async []int
+	for i, rule := range e.rules {
+		if o.done[i] {
+			continue
+		}
+		if !e.inline(rule) {
+			async = append(async, i)
+		} else if !rule.builtin && ctx.Err() != nil {
+			x.timeout(o, i, parent, start)
+		} else {
+			x.record(o, rule.explain(ctx, i, val), ctx, parent)
+		}
+	}
+
+	// the channel is buffered so that the rules finishing after the timeout don't block, see
+	// Evaluator.examine.
+	ch := make(chan explainChannel, len(async))
+	e.dispatch(ctx, async, func(i int) {
+		ch <- e.rules[i].explain(ctx, i, val)
+	})
+
+wait:
+	for range async {
+		select {
+		case ret := <-ch:
+			x.record(o, ret, ctx, parent)
+		case <-ctx.Done():
+			// the results sent before ctx is done are kept
+			for len(ch) > 0 {
+				x.record(o, <-ch, ctx, parent)
+			}
+			for _, i := range async {
+				if !o.done[i] {
+					x.timeout(o, i, parent, start)
+				}
+			}
+			break wait
+		}
+	}
+
+	x.Result, x.Err = o.result(p.expr)
+	x.Duration = time.Since(start)
+
+	return x
+}
+
+// record sets the outcome of a rule examined with ctx.
+func (x *Explanation) record(o *outcomes, ret explainChannel, ctx, parent context.Context) {
+	ret.err = ruleError(ctx, parent, ret.err)
+	r := &x.Rules[ret.rule]
+	r.Actual, r.Result, r.Err, r.Duration = ret.actual, ret.rst, ret.err, ret.duration
+	if ret.err == errSkip {
+		// the rule is skipped by the policy of the parser and passes
+		r.Result, r.Err = true, nil
+	}
+	o.record(ret.rule, ret.rst, ret.err)
+}
+
+// timeout sets the outcome of the rule i which is not finished when ctx is done.
+func (x *Explanation) timeout(o *outcomes, i int, parent context.Context, start time.Time) {
+	x.Rules[i].Err = doneError(parent)
+	x.Rules[i].Duration = time.Since(start)
+	o.record(i, false, x.Rules[i].Err)
+}
+
+func (r *compiledRule) explain(ctx context.Context, idx int, val reflect.Value) explainChannel {
+	start := time.Now()
+	var actual interface{}
+	fv := r.value(val)
+	rst, err := r.examine(ctx, fv)
+	if fv.IsValid() && fv.CanInterface() {
+		actual = fv.Interface()
+	}
+	return explainChannel{idx, actual, rst, err, time.Since(start)}
+}
@@ -0,0 +1,338 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"github.com/kuangwanjing/ruleparser/ast"
+	"go/token"
+	"reflect"
+)
+
+// exprNode is the boolean expression of the rules compiled from the syntax tree. A leaf refers
+// to a single rule by its index while the other nodes combine their children with `&&`, `||`
+// or `!`. Parentheses are dropped and `;` is turned into `&&`.
+type exprNode struct {
+	op   token.Token // token.LAND, token.LOR, token.NOT or token.ILLEGAL for a leaf
+	x, y *exprNode
+	rule int
+}
+
+func compileExpr(x ast.Expr, index map[*ast.CmpExpr]int) *exprNode {
+	switch n := x.(type) {
+	case *ast.ParenExpr:
+		return compileExpr(n.X, index)
+	case *ast.UnaryExpr:
+		return &exprNode{op: token.NOT, x: compileExpr(n.X, index)}
+	case *ast.BinaryExpr:
+		op := n.Op
+		if op == token.SEMICOLON {
+			op = token.LAND
+		}
+		return &exprNode{op: op, x: compileExpr(n.X, index), y: compileExpr(n.Y, index)}
+	case *ast.CmpExpr:
+		return &exprNode{op: token.ILLEGAL, rule: index[n]}
+	}
+	return nil
+}
+
+// outcomes holds the results of the rules of an examination, indexed like the rules. A skipped
+// rule is not examined and passes wherever it appears, and a rule which failed with an error
+// only fails the examination when the result depends on it, see exprNode.resolve.
+type outcomes struct {
+	done []bool
+	rst  []bool
+	skip []bool
+	errs []error
+}
+
+func newOutcomes(n int) *outcomes {
+	return &outcomes{
+		done: make([]bool, n),
+		rst:  make([]bool, n),
+		skip: make([]bool, n),
+		errs: make([]error, n),
+	}
+}
+
+// errSkip is returned by a rule which is skipped on its examination, see NilSkip and FailOpen.
+var errSkip = errors.New("rule is skipped")
+
+// record sets the outcome of the rule i.
+func (o *outcomes) record(i int, rst bool, err error) {
+	if err == errSkip {
+		o.skipped(i)
+		return
+	}
+	o.done[i], o.rst[i], o.errs[i] = true, rst, err
+}
+
+// skipped marks the rule i as skipped.
+func (o *outcomes) skipped(i int) {
+	o.done[i], o.skip[i] = true, true
+}
+
+// result returns the result of the expression once every rule it depends on is examined.
+func (o *outcomes) result(x *exprNode) (bool, error) {
+	v, ok, err := x.resolve(o, false)
+	if !ok {
+		return false, err
+	}
+	return v, nil
+}
+
+// resolve evaluates the expression with the outcomes of the rules known so far. ok is false when
+// the result still depends on rules which have not been examined yet or failed with an error, in
+// which case err is the first error the result depends on. neg tells whether the node is under
+// an odd number of `!`: a skipped rule takes the value which makes it pass there, so that
+// negating a rule which is not examined doesn't turn it into a failure.
+func (n *exprNode) resolve(o *outcomes, neg bool) (val bool, ok bool, err error) {
+	switch n.op {
+	case token.NOT:
+		v, ok, err := n.x.resolve(o, !neg)
+		return !v, ok, err
+	case token.LAND, token.LOR:
+		// the result is decided by either side having the value of the operator: false for
+		// `&&` and true for `||`.
+		decisive := n.op == token.LOR
+		xv, xok, xerr := n.x.resolve(o, neg)
+		if xok && xv == decisive {
+			return decisive, true, nil
+		}
+		yv, yok, yerr := n.y.resolve(o, neg)
+		if yok && yv == decisive {
+			return decisive, true, nil
+		}
+		if xok && yok {
+			return !decisive, true, nil
+		}
+		if xerr == nil {
+			xerr = yerr
+		}
+		return false, false, xerr
+	}
+	if o.skip[n.rule] {
+		return !neg, true, nil
+	}
+	if o.errs[n.rule] != nil {
+		return false, false, o.errs[n.rule]
+	}
+	return o.rst[n.rule], o.done[n.rule], nil
+}
+
+// precedence of the logical operators. `;` binds loosest so that the rules separated by it are
+// ANDed as a whole, which keeps the flat syntax of the rules backward compatible.
+func precedence(op token.Token) int {
+	switch op {
+	case token.NOT:
+		return 4
+	case token.LAND:
+		return 3
+	case token.LOR:
+		return 2
+	case token.SEMICOLON:
+		return 1
+	}
+	return 0
+}
+
+// exprBuilder builds the syntax tree from the rules and the logical operators met by the
+// scanner with the shunting-yard algorithm.
+type exprBuilder struct {
+	nodes  []ast.Expr
+	ops    []token.Token
+	pos    []token.Pos
+	sep    bool      // a `;` is met and waits for the next rule
+	sepPos token.Pos // position of the pending `;`
+}
+
+// rule appends a single rule.
+func (b *exprBuilder) rule(exp *ast.CmpExpr) error {
+	if err := b.flushSep(); err != nil {
+		return err
+	}
+	b.nodes = append(b.nodes, exp)
+	return nil
+}
+
+// push handles a logical operator or a parenthesis.
+func (b *exprBuilder) push(pos token.Pos, tok token.Token) error {
+	switch tok {
+	case token.NOT, token.LPAREN:
+		if err := b.flushSep(); err != nil {
+			return err
+		}
+		b.ops = append(b.ops, tok)
+		b.pos = append(b.pos, pos)
+	case token.SEMICOLON:
+		// the separator is only taken as an operator when another rule follows it,
+		// so that a trailing `;` is allowed.
+		b.sep, b.sepPos = true, pos
+	case token.LAND, token.LOR:
+		return b.binary(pos, tok)
+	case token.RPAREN:
+		for len(b.ops) > 0 && b.ops[len(b.ops)-1] != token.LPAREN {
+			if err := b.reduce(); err != nil {
+				return err
+			}
+		}
+		if len(b.ops) == 0 || len(b.nodes) == 0 {
+			return syntaxError(pos, "unexpected `)` at %d", pos)
+		}
+		x := b.nodes[len(b.nodes)-1]
+		b.nodes[len(b.nodes)-1] = &ast.ParenExpr{Lparen: b.pos[len(b.pos)-1], X: x, Rparen: pos}
+		b.ops = b.ops[:len(b.ops)-1]
+		b.pos = b.pos[:len(b.pos)-1]
+	}
+	return nil
+}
+
+func (b *exprBuilder) flushSep() error {
+	if !b.sep {
+		return nil
+	}
+	b.sep = false
+	return b.binary(b.sepPos, token.SEMICOLON)
+}
+
+func (b *exprBuilder) binary(pos token.Pos, tok token.Token) error {
+	for len(b.ops) > 0 {
+		top := b.ops[len(b.ops)-1]
+		if top == token.LPAREN || precedence(top) < precedence(tok) {
+			break
+		}
+		if err := b.reduce(); err != nil {
+			return err
+		}
+	}
+	b.ops = append(b.ops, tok)
+	b.pos = append(b.pos, pos)
+	return nil
+}
+
+// reduce pops the operator on the top of the stack and combines its operands.
+func (b *exprBuilder) reduce() error {
+	op, pos := b.ops[len(b.ops)-1], b.pos[len(b.pos)-1]
+	b.ops, b.pos = b.ops[:len(b.ops)-1], b.pos[:len(b.pos)-1]
+
+	if op == token.NOT {
+		if len(b.nodes) < 1 {
+			return syntaxError(pos, "rule is expected after `!` at %d", pos)
+		}
+		x := b.nodes[len(b.nodes)-1]
+		b.nodes[len(b.nodes)-1] = &ast.UnaryExpr{OpPos: pos, Op: op, X: x}
+		return nil
+	}
+
+	if len(b.nodes) < 2 {
+		return syntaxError(pos, "rule is expected after `%s` at %d", op.String(), pos)
+	}
+	x, y := b.nodes[len(b.nodes)-2], b.nodes[len(b.nodes)-1]
+	b.nodes = b.nodes[:len(b.nodes)-2]
+	b.nodes = append(b.nodes, &ast.BinaryExpr{X: x, OpPos: pos, Op: op, Y: y})
+	return nil
+}
+
+// finish reduces the remaining operators and returns the root of the syntax tree.
+func (b *exprBuilder) finish() (ast.Expr, error) {
+	for len(b.ops) > 0 {
+		if b.ops[len(b.ops)-1] == token.LPAREN {
+			return nil, syntaxError(b.pos[len(b.pos)-1], "`(` at %d is not closed", b.pos[len(b.pos)-1])
+		}
+		if err := b.reduce(); err != nil {
+			return nil, err
+		}
+	}
+	if len(b.nodes) == 0 {
+		return nil, syntaxError(token.NoPos, "no rules to parse")
+	}
+	return b.nodes[0], nil
+}
+
+// checkLit verifies that the typed value of the literal, and of the elements of a list, matches
+// the literal as written.
+func checkLit(x *ast.BasicLit) error {
+	if x.Kind == ast.Version {
+		if _, err := parseSemver(x.Value); err != nil {
+			return err
+		}
+	}
+	if x.Kind != ast.List {
+		lit, err := ast.NewBasicLit(x.ValuePos, x.Kind, x.Value)
+		if err != nil {
+			return err
+		}
+		if x.Val != lit.Val {
+			return errors.New(fmt.Sprintf("%v does not match its literal %s", x.Val, x.Value))
+		}
+		return nil
+	}
+
+	for _, elt := range x.Elts {
+		if elt == nil {
+			return errors.New(fmt.Sprintf("invalid element of the list at %d", x.ValuePos))
+		}
+		if err := checkLit(elt); err != nil {
+			return err
+		}
+	}
+	list, err := ast.NewListLit(x.ValuePos, x.Elts, x.Rbrack)
+	if err != nil {
+		return err
+	}
+	if x.Value != list.Value || !reflect.DeepEqual(x.Val, list.Val) {
+		return errors.New(fmt.Sprintf("%v does not match its elements %s", x.Val, list.Value))
+	}
+	return nil
+}
+
+// checkExpr verifies that a syntax tree, which might be built or rewritten by hand, can be
+// examined by the parser.
+func checkExpr(x ast.Expr) error {
+	var err error
+	ast.Inspect(x, func(n ast.Node) bool {
+		if err != nil || n == nil {
+			return false
+		}
+		switch n := n.(type) {
+		case *ast.CmpExpr:
+			if n.Operand() == "" {
+				err = syntaxError(n.OpPos, "operand is missing at %d", n.OpPos)
+			} else if c, ok := n.X.(*ast.CallExpr); ok && (c.Fun == nil || !ast.IsFunc(c.Fun.Name)) {
+				err = syntaxError(n.OpPos, "unknown function is applied to %s", n.Operand())
+			} else if kerr := checkIndex(n.X); kerr != nil {
+				err = causedSyntaxError(n.OpPos, kerr, "index of %s is not valid: %v", n.Operand(), kerr)
+			} else if n.Op == "" {
+				err = syntaxError(n.OpPos, "operation is missing for %s", n.Operand())
+			} else if n.IsCall() && !ast.IsCallOp(n.Op) {
+				err = syntaxError(n.OpPos, "%s can not be written as a call at %d", n.Op, n.OpPos)
+			} else if ast.IsUnaryOp(n.Op) {
+				if n.Y != nil || n.IsCall() {
+					err = syntaxError(n.OpPos, "%s takes no value at %d", n.Op, n.OpPos)
+				}
+			} else if n.Y == nil {
+				err = syntaxError(n.OpPos, "value is missing for %s", n.Operand())
+			} else if n.Y.Kind == ast.Nil && n.Op != "is" && n.Op != "==" && n.Op != "!=" {
+				err = syntaxError(n.Y.ValuePos, "nil is only compared with is, == or != at %d", n.Y.ValuePos)
+			} else if lerr := checkLit(n.Y); lerr != nil {
+				err = causedSyntaxError(n.Y.ValuePos, lerr, "value of %s is not valid: %v", n.Operand(), lerr)
+			}
+			return false
+		case *ast.UnaryExpr:
+			if n.Op != token.NOT || n.X == nil {
+				err = syntaxError(n.OpPos, "%s is not accepted as a unary operator", n.Op.String())
+			}
+		case *ast.BinaryExpr:
+			if n.Op != token.LAND && n.Op != token.LOR && n.Op != token.SEMICOLON || n.X == nil || n.Y == nil {
+				err = syntaxError(n.OpPos, "%s is not accepted as a binary operator", n.Op.String())
+			}
+		case *ast.ParenExpr:
+			if n.X == nil {
+				err = syntaxError(n.Lparen, "empty group at %d", n.Lparen)
+			}
+		case *ast.Ident, *ast.SelectorExpr, *ast.IndexExpr, *ast.CallExpr, *ast.BasicLit:
+			err = syntaxError(n.Pos(), "rule is expected at %d", n.Pos())
+		}
+		return err == nil
+	})
+	return err
+}
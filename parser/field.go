@@ -0,0 +1,135 @@
+package parser
+
+import (
+	"reflect"
+	"strings"
+)
+
+// parseTag splits the struct tag into the name and the options following it, such as
+// `rule:"ver,semver"`.
+func parseTag(tag string) (string, []string) {
+	parts := strings.Split(tag, ",")
+	return parts[0], parts[1:]
+}
+
+// hasOption tells whether the struct tag of the field carries the option.
+func hasOption(field reflect.StructField, option string) bool {
+	_, opts := parseTag(field.Tag.Get(tagName))
+	return containsString(opts, option)
+}
+
+// taggedField returns the field of the struct type tagged with the name. The tagged fields of
+// embedded structs, or pointers to structs, which are not tagged themselves are promoted in the
+// same way as encoding/json does: a field at a shallower depth hides the fields at deeper depths,
+// and the fields tagged with the same name at the same depth annihilate each other so that none
+// of them is bound. Unexported fields are not bound. The Index of the returned field is the
+// index sequence from t.
+func taggedField(t reflect.Type, name string) (reflect.StructField, bool) {
+	type embedded struct {
+		t     reflect.Type
+		index []int
+	}
+
+	visited := map[reflect.Type]bool{}
+	next := []embedded{{t, nil}}
+	for len(next) > 0 {
+		current := next
+		next = nil
+
+		var found []reflect.StructField
+		for _, em := range current {
+			for i := 0; i < em.t.NumField(); i++ {
+				field := em.t.Field(i)
+				if field.PkgPath != "" && !field.Anonymous {
+					// unexported fields are ignored, while the exported fields of an unexported
+					// embedded struct are still promoted
+					continue
+				}
+				tag, _ := parseTag(field.Tag.Get(tagName))
+				if tag == "-" {
+					continue
+				}
+
+				index := make([]int, len(em.index)+1)
+				copy(index, em.index)
+				index[len(em.index)] = i
+
+				if tag == name {
+					field.Index = index
+					found = append(found, field)
+				} else if tag == "" && field.Anonymous {
+					if ft := elemType(field.Type); ft.Kind() == reflect.Struct && !visited[ft] {
+						next = append(next, embedded{ft, index})
+					}
+				}
+			}
+		}
+
+		if len(found) == 1 {
+			return found[0], true
+		} else if len(found) > 1 {
+			// the fields conflict with each other
+			return reflect.StructField{}, false
+		}
+
+		// the structs of the current depth are marked after the whole depth is searched, so a
+		// struct embedded twice at the same depth conflicts with itself.
+		for _, em := range current {
+			visited[em.t] = true
+		}
+	}
+
+	return reflect.StructField{}, false
+}
+
+// fieldByOperand finds the field of the struct type bound to the operand. A field tagged with
+// the whole operand is preferred, otherwise each segment of a dotted operand such as
+// "device.os.version" is bound to a field of the struct, or of the struct pointed to, which the
+// former segment is bound to. The Index of the returned field is the index sequence from t.
+func fieldByOperand(t reflect.Type, operand string) (reflect.StructField, bool) {
+	if field, ok := taggedField(t, operand); ok {
+		return field, true
+	}
+
+	segments := strings.Split(operand, ".")
+	if len(segments) == 1 {
+		return reflect.StructField{}, false
+	}
+
+	var index []int
+	var field reflect.StructField
+	for i, seg := range segments {
+		if i > 0 {
+			t = elemType(field.Type)
+			if t.Kind() != reflect.Struct {
+				return reflect.StructField{}, false
+			}
+		}
+		f, ok := taggedField(t, seg)
+		if !ok {
+			return reflect.StructField{}, false
+		}
+		field = f
+		index = append(index, f.Index...)
+	}
+
+	field.Index = index
+	return field, true
+}
+
+// fieldValue returns the nested field of the struct by its index sequence, following the
+// pointers between the structs. ok is false when a nil pointer is met on the way.
+func fieldValue(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 {
+			for v.Kind() == reflect.Ptr {
+				if v.IsNil() {
+					return reflect.Value{}, false
+				}
+				v = v.Elem()
+			}
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
@@ -2,9 +2,12 @@ package parser
 
 import (
 	"errors"
+	"fmt"
+	"github.com/kuangwanjing/ruleparser/ast"
 	"reflect"
 	"strconv"
 	"strings"
+	"time"
 )
 
 var basicOperations []string = []string{
@@ -109,11 +112,27 @@ func BasicLessThanOrEqual(cmp int) bool {
 }
 
 func ConvertOperationName(op string) string {
+	op = operationName(op)
 	return strings.ToUpper(string(op[0])) + op[1:]
 }
 
 func BasicCmp(val interface{}, cmpVal string) (int, error) {
 
+	switch v := val.(type) {
+	case time.Time:
+		at, err := parseTime(cmpVal, time.Now)
+		if err != nil {
+			return -1, err
+		}
+		return v.Compare(at()), nil
+	case time.Duration:
+		d, err := parseDuration(cmpVal)
+		if err != nil {
+			return -1, err
+		}
+		return cmpInt64(int64(v), int64(d)), nil
+	}
+
 	k := reflect.TypeOf(val).Kind()
 
 	switch k {
@@ -172,3 +191,110 @@ func BasicCmp(val interface{}, cmpVal string) (int, error) {
 
 	return 0, errors.New("type error for " + cmpVal)
 }
+
+// literalMatches tells whether a literal of the rule can be compared with a field of the kind.
+// Integers are accepted by float fields, and 0 and 1 by bool fields for compatibility. Versions
+// are accepted by string fields, which are compared by semantic versioning.
+func literalMatches(k reflect.Kind, lit *ast.BasicLit) bool {
+	switch k {
+	case reflect.String:
+		return lit.Kind == ast.String || lit.Kind == ast.Version
+	case reflect.Bool:
+		if i, ok := lit.Val.(int64); ok {
+			return i == 0 || i == 1
+		}
+		return lit.Kind == ast.Bool
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return lit.Kind == ast.Int
+	case reflect.Float32, reflect.Float64:
+		return lit.Kind == ast.Int || lit.Kind == ast.Float
+	}
+	return false
+}
+
+// CmpLiteral compares a value of basic data type with the typed literal of a rule. Unlike
+// BasicCmp, the literal is not parsed again on every comparison.
+func CmpLiteral(val interface{}, lit *ast.BasicLit) (int, error) {
+	return cmpLiteral(reflect.ValueOf(val), lit)
+}
+
+func cmpLiteral(v reflect.Value, lit *ast.BasicLit) (int, error) {
+	if !literalMatches(v.Kind(), lit) {
+		return 0, errors.New(fmt.Sprintf("%s literal %s does not match %s", lit.Kind, lit.Value, v.Kind()))
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		if lit.Kind == ast.Version {
+			return cmpSemver(v.String(), lit.Value)
+		}
+		return strings.Compare(v.String(), lit.Val.(string)), nil
+	case reflect.Bool:
+		b, ok := lit.Val.(bool)
+		if !ok {
+			b = lit.Val.(int64) == 1
+		}
+		if v.Bool() == b {
+			return 0, nil
+		}
+		return 1, nil
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int, reflect.Int64:
+		if _, ok := lit.Val.(uint64); ok {
+			// the literal is beyond the range of int64
+			return -1, nil
+		}
+		return cmpInt64(v.Int(), lit.Val.(int64)), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if i, ok := lit.Val.(int64); ok {
+			if i < 0 {
+				return 1, nil
+			}
+			return cmpUint64(v.Uint(), uint64(i)), nil
+		}
+		return cmpUint64(v.Uint(), lit.Val.(uint64)), nil
+	case reflect.Float32, reflect.Float64:
+		return cmpFloat64(v.Float(), literalFloat(lit)), nil
+	}
+
+	return 0, errors.New("type error for " + lit.Value)
+}
+
+func literalFloat(lit *ast.BasicLit) float64 {
+	switch x := lit.Val.(type) {
+	case int64:
+		return float64(x)
+	case uint64:
+		return float64(x)
+	case float64:
+		return x
+	}
+	return 0
+}
+
+func cmpInt64(a, b int64) int {
+	if a == b {
+		return 0
+	} else if a < b {
+		return -1
+	}
+	return 1
+}
+
+func cmpUint64(a, b uint64) int {
+	if a == b {
+		return 0
+	} else if a < b {
+		return -1
+	}
+	return 1
+}
+
+func cmpFloat64(a, b float64) int {
+	if a == b {
+		return 0
+	} else if a < b {
+		return -1
+	}
+	return 1
+}
@@ -0,0 +1,186 @@
+package parser
+
+import (
+	"context"
+	"github.com/kuangwanjing/ruleparser/ast"
+	"reflect"
+)
+
+// indexKeys returns the keys of the index expressions of the operand, from the outermost map to
+// the innermost one, such as `geo` and `country` of attributes[`geo`][`country`].
+func indexKeys(x ast.Expr) []*ast.BasicLit {
+	n, ok := x.(*ast.IndexExpr)
+	if !ok {
+		return nil
+	}
+	return append(indexKeys(n.X), n.Index)
+}
+
+// checkIndex verifies that the keys of the index expressions of the operand are string or
+// integer literals.
+func checkIndex(x ast.Expr) error {
+	for _, key := range indexKeys(x) {
+		if key.Kind != ast.String && key.Kind != ast.Int {
+			return syntaxError(key.ValuePos, "%s literal %s is not accepted as a key at %d", key.Kind, key.Value, key.ValuePos)
+		}
+		if err := checkLit(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mapKey converts the literal into a key of the type, which must be of string kind for a string
+// literal or of an integer kind for an integer literal.
+func mapKey(rule *ast.CmpExpr, kt reflect.Type, lit *ast.BasicLit) (reflect.Value, error) {
+	mismatch := typeError(rule, lit.Kind.String()+" literal "+lit.Value+" does not match "+kt.Kind().String()+
+		" key of "+rule.Operand())
+
+	key := reflect.New(kt).Elem()
+	switch kt.Kind() {
+	case reflect.String:
+		if s, ok := lit.Val.(string); ok {
+			key.SetString(s)
+			return key, nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i, ok := lit.Val.(int64); ok && !key.OverflowInt(i) {
+			key.SetInt(i)
+			return key, nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		switch u := lit.Val.(type) {
+		case int64:
+			if u >= 0 && !key.OverflowUint(uint64(u)) {
+				key.SetUint(uint64(u))
+				return key, nil
+			}
+		case uint64:
+			if !key.OverflowUint(u) {
+				key.SetUint(u)
+				return key, nil
+			}
+		}
+	}
+	return key, mismatch
+}
+
+// missingKey returns the result of a rule whose key is not found in the map: the rule fails,
+// whatever its operation is, unless the parser is strict, which reports the key as an error.
+func (p *RuleParser) missingKey(rule *ast.CmpExpr, key *ast.BasicLit) (bool, error) {
+	if p.strict {
+		return false, &OperandError{Operands: []string{rule.Operand()}, Key: key}
+	}
+	return false, nil
+}
+
+// compileIndex returns the function looking up the entry of a map of type t by the keys of the
+// operand, and examining it with the function elem compiles for the type of the entry. A map of
+// interface type, and every map within it, is looked up according to its dynamic type. The
+// presence of the entry is examined without looking up the missing key, see compilePresence.
+func (p *RuleParser) compileIndex(rule *ast.CmpExpr, keys []*ast.BasicLit, t reflect.Type,
+	elem func(reflect.Type) (func(context.Context, reflect.Value) (bool, error), bool, error)) (
+	func(context.Context, reflect.Value) (bool, error), bool, error) {
+	lookups := make([]func(reflect.Value) (reflect.Value, bool, error), len(keys))
+	for i, lit := range keys {
+		lit := lit
+		if t.Kind() == reflect.Interface {
+			lookups[i] = func(v reflect.Value) (reflect.Value, bool, error) {
+				v, ok := dynamicValue(v)
+				if !ok {
+					return v, false, nil
+				}
+				if v.Kind() != reflect.Map {
+					return v, false, typeError(rule, "index is not available for "+v.Type().String()+" value "+
+						rule.Operand())
+				}
+				key, err := mapKey(rule, v.Type().Key(), lit)
+				if err != nil {
+					return v, false, err
+				}
+				v = v.MapIndex(key)
+				return v, v.IsValid(), nil
+			}
+			continue
+		}
+
+		mt := elemType(t)
+		if mt.Kind() != reflect.Map {
+			return nil, false, typeError(rule, "index is not available for "+mt.Kind().String()+" field "+
+				rule.Operand())
+		}
+		key, err := mapKey(rule, mt.Key(), lit)
+		if err != nil {
+			return nil, false, err
+		}
+		lookups[i] = func(v reflect.Value) (reflect.Value, bool, error) {
+			for v.Kind() == reflect.Ptr {
+				if v.IsNil() {
+					// a nil pointer to a map holds no key, like a nil map
+					return v, false, nil
+				}
+				v = v.Elem()
+			}
+			v = v.MapIndex(key)
+			return v, v.IsValid(), nil
+		}
+		t = mt.Elem()
+	}
+
+	fn, builtin, err := elem(t)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return func(ctx context.Context, v reflect.Value) (bool, error) {
+		for i, lookup := range lookups {
+			var ok bool
+			var err error
+			if v, ok, err = lookup(v); err != nil {
+				return false, err
+			} else if !ok && isPresenceRule(rule) {
+				return fn(ctx, reflect.Value{})
+			} else if !ok {
+				return p.missingKey(rule, keys[i])
+			}
+		}
+		return fn(ctx, v)
+	}, builtin, nil
+}
+
+// compileHasKey returns the function telling whether a map of type t holds the key of the rule.
+// A nil map holds no key.
+func compileHasKey(rule *ast.CmpExpr, t reflect.Type) (func(context.Context, reflect.Value) (bool, error), error) {
+	key, err := mapKey(rule, elemType(t).Key(), rule.Y)
+	if err != nil {
+		return nil, err
+	}
+	return func(_ context.Context, v reflect.Value) (bool, error) {
+		for v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return false, nilError("pointer", rule)
+			}
+			v = v.Elem()
+		}
+		return v.MapIndex(key).IsValid(), nil
+	}, nil
+}
+
+// compileDynamicHasKey returns the function telling whether a value, whose type is only known
+// when the context is examined, is a map holding the key of the rule.
+func compileDynamicHasKey(rule *ast.CmpExpr) func(context.Context, reflect.Value) (bool, error) {
+	return func(_ context.Context, v reflect.Value) (bool, error) {
+		v, ok := dynamicValue(v)
+		if !ok {
+			return false, nilError("value", rule)
+		}
+		if v.Kind() != reflect.Map {
+			return false, typeError(rule, rule.Op+" is not available for "+v.Type().String()+" value "+rule.Operand())
+		}
+		key, err := mapKey(rule, v.Type().Key(), rule.Y)
+		if err != nil {
+			return false, err
+		}
+		return v.MapIndex(key).IsValid(), nil
+	}
+}
@@ -0,0 +1,78 @@
+package parser
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/kuangwanjing/ruleparser/ast"
+	"io"
+	"reflect"
+	"strconv"
+)
+
+// ExamineJSON examines the JSON document, which must be an object, with the rules without
+// decoding it into a struct. Each operand is a path of the keys through the nested objects, such
+// as "device.os.version", and the rules whose path is not found in the document pass unless the
+// parser is strict. Strings, numbers and booleans are compared with the literals. Arrays are
+// examined with the built-in any, all, len and contains, and objects with indexing and has_key,
+// like the slices and maps of a struct. The other operations are examined by the registered
+// operators, which receive the numbers as json.Number. Numbers are not strings, so the string
+// operations and the regular expressions fail on them with a *TypeError.
+func (p *RuleParser) ExamineJSON(data []byte) (bool, error) {
+	return p.ExamineJSONContext(context.Background(), data)
+}
+
+// ExamineJSONContext is ExamineJSON honoring the cancellation and the deadline of ctx in the same
+// way as ExamineContext does.
+func (p *RuleParser) ExamineJSONContext(ctx context.Context, data []byte) (bool, error) {
+	doc, err := decodeJSON(data)
+	if err != nil {
+		return false, err
+	}
+	return p.ExamineContext(ctx, doc)
+}
+
+// decodeJSON decodes the JSON object keeping the numbers as they are written, so that integers
+// beyond the precision of float64 are compared exactly.
+func decodeJSON(data []byte) (map[string]interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var doc map[string]interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, &ContextError{Msg: "invalid JSON document: " + err.Error(), Err: err}
+	}
+	if doc == nil {
+		return nil, &ContextError{Msg: "JSON document is not an object"}
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, &ContextError{Msg: "invalid JSON document: data is found after the object"}
+	}
+	return doc, nil
+}
+
+var numberType = reflect.TypeOf(json.Number(""))
+
+// numberValue converts the JSON number into an integer when it is compared with an integer
+// literal, or a list of integers, and can be held by an integer, and into a float otherwise.
+func numberValue(n json.Number, lit *ast.BasicLit) reflect.Value {
+	integer := lit.Kind == ast.Int
+	if lit.Kind == ast.List {
+		integer = true
+		for _, elt := range lit.Elts {
+			integer = integer && elt.Kind == ast.Int
+		}
+	}
+	if integer {
+		if i, err := strconv.ParseInt(string(n), 10, 64); err == nil {
+			return reflect.ValueOf(i)
+		}
+		if u, err := strconv.ParseUint(string(n), 10, 64); err == nil {
+			return reflect.ValueOf(u)
+		}
+	}
+	if f, err := strconv.ParseFloat(string(n), 64); err == nil {
+		return reflect.ValueOf(f)
+	}
+	return reflect.ValueOf(string(n))
+}
@@ -0,0 +1,138 @@
+package parser
+
+import (
+	"go/scanner"
+	"go/token"
+	"strings"
+)
+
+type lexItem struct {
+	pos token.Pos
+	tok token.Token
+	lit string
+}
+
+// lexer wraps the scanner of Go and merges the sign of a negative number into the number, so
+// that "-3" reaches the state machine as a single INT token whose literal is "-3". The arrow
+// of "a<-3" is split into "<" and "-3" for the same reason. The operation "=~" is merged into a
+// single TILDE token whose literal is "=~". The semicolon inserted by the scanner at the end of a
+// line is dropped when the next line starts with "&&", "||" or ")", so that the rules can be
+// broken into lines before a logical operator. A semantic version written without quotes, such as
+// 1.3.2 or v2.0.0-rc.1, is scanned as several tokens with no space between them, which are merged
+// into a single FLOAT token whose literal is the version.
+type lexer struct {
+	s    scanner.Scanner
+	src  string
+	next []lexItem
+}
+
+func newLexer(rules string) *lexer {
+	l := &lexer{src: rules}
+	fset := token.NewFileSet()                        // positions are relative to fset
+	file := fset.AddFile("", fset.Base(), len(rules)) // register input "file"
+	l.s.Init(file, []byte(rules), nil /* no error handler */, scanner.ScanComments)
+	return l
+}
+
+func (l *lexer) scan() lexItem {
+	if len(l.next) > 0 {
+		it := l.next[0]
+		l.next = l.next[1:]
+		return it
+	}
+	pos, tok, lit := l.s.Scan()
+	return lexItem{pos, tok, lit}
+}
+
+// unscan puts the token back, so that it is the next one scanned.
+func (l *lexer) unscan(it lexItem) {
+	l.next = append([]lexItem{it}, l.next...)
+}
+
+// Scan returns the next token like scanner.Scanner.Scan does.
+func (l *lexer) Scan() (token.Pos, token.Token, string) {
+	it := l.version(l.scan())
+	if it.tok == token.SEMICOLON && it.lit == "\n" {
+		next := l.scan()
+		l.unscan(next)
+		switch next.tok {
+		case token.LAND, token.LOR, token.RPAREN:
+			return l.Scan()
+		}
+		return it.pos, it.tok, it.lit
+	}
+	if it.tok == token.ASSIGN {
+		tilde := l.scan()
+		if tilde.tok == token.TILDE && tilde.pos == it.pos+1 {
+			return it.pos, token.TILDE, "=~"
+		}
+		l.unscan(tilde)
+		return it.pos, it.tok, it.lit
+	}
+	if it.tok != token.SUB && it.tok != token.ARROW {
+		return it.pos, it.tok, it.lit
+	}
+
+	num := l.scan()
+	if num.tok != token.INT && num.tok != token.FLOAT {
+		l.unscan(num)
+		return it.pos, it.tok, it.lit
+	}
+
+	if it.tok == token.ARROW {
+		l.unscan(lexItem{it.pos + 1, num.tok, "-" + num.lit})
+		return it.pos, token.LSS, token.LSS.String()
+	}
+	return it.pos, num.tok, "-" + num.lit
+}
+
+// end returns the position following the token.
+func (it lexItem) end() token.Pos {
+	if it.lit != "" {
+		return it.pos + token.Pos(len(it.lit))
+	}
+	return it.pos + token.Pos(len(it.tok.String()))
+}
+
+// version merges the tokens of a semantic version starting with it, which is a number or an
+// identifier such as v1, followed by a number starting with a second dot.
+func (l *lexer) version(it lexItem) lexItem {
+	if it.tok != token.INT && it.tok != token.FLOAT && (it.tok != token.IDENT || !isVersionPrefix(it.lit)) {
+		return it
+	}
+	next := l.scan()
+	l.unscan(next)
+	if next.tok != token.FLOAT || next.pos != it.end() || !strings.HasPrefix(next.lit, ".") {
+		return it
+	}
+
+	lit, last := it.lit, it.tok
+	for {
+		next := l.scan()
+		end := it.pos + token.Pos(len(lit))
+		switch next.tok {
+		case token.INT, token.FLOAT, token.IDENT, token.PERIOD, token.SUB, token.ADD:
+			if next.pos == end {
+				if next.lit != "" {
+					lit += next.lit
+				} else {
+					lit += next.tok.String()
+				}
+				last = next.tok
+				continue
+			}
+		}
+		l.unscan(next)
+		if last != token.INT && last != token.FLOAT && last != token.IDENT &&
+			(next.tok == token.EOF || strings.Contains(l.src[end-1:next.pos-1], "\n")) {
+			// the scanner doesn't end the line after an operator, while it does after a version
+			l.unscan(lexItem{end, token.SEMICOLON, "\n"})
+		}
+		return lexItem{it.pos, token.FLOAT, lit}
+	}
+}
+
+// isVersionPrefix tells whether the identifier is v followed by a number, as in v1.2.3.
+func isVersionPrefix(s string) bool {
+	return len(s) > 1 && s[0] == 'v' && isNumeric(s[1:])
+}
@@ -0,0 +1,220 @@
+package parser
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/kuangwanjing/ruleparser/ast"
+	"reflect"
+	"strings"
+)
+
+// bindMap binds the rules to the entries of a map type keyed by string. Every rule is bound, as
+// the keys are only known when a context is examined; the rules whose operand is not found in
+// the context are then skipped like the operands not bound to any field of a struct.
+func (p *RuleParser) bindMap(t reflect.Type) (*Evaluator, []error) {
+	if t.Key().Kind() != reflect.String {
+		return nil, []error{&ContextError{Type: t, Msg: t.String() + " is not accepted"}}
+	}
+
+	var errs []error
+	e := &Evaluator{p: p, typ: t, rules: make([]*compiledRule, p.ruleCount)}
+	for idx, rule := range p.rules {
+		examine, builtin, err := p.compileEntry(rule, t.Elem())
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		e.rules[idx] = &compiledRule{rule, builtin, nil, p.guard(rule, examine)}
+		e.count += 1
+		if !builtin {
+			e.methods += 1
+		}
+	}
+
+	return e, errs
+}
+
+// compileEntry returns the function examining an entry of the map with the element type t. The
+// values of interface type, and the entries of nested maps, are examined according to their
+// dynamic type, while the values of any other type are examined like the fields of a struct.
+func (p *RuleParser) compileEntry(rule *ast.CmpExpr, t reflect.Type) (func(context.Context, reflect.Value) (bool, error), bool, error) {
+	elem := func(t reflect.Type) (func(context.Context, reflect.Value) (bool, error), bool, error) {
+		if isPresenceRule(rule) {
+			return compilePresence(rule), true, nil
+		}
+		if t.Kind() == reflect.Map {
+			return p.compileDynamicValue(rule)
+		}
+		return p.compileValue(rule, t)
+	}
+
+	if name := rule.Func(); name != "" && isPresenceRule(rule) {
+		return nil, false, typeError(rule, rule.Op+" is not available for "+name+"("+rule.Operand()+")")
+	} else if name != "" {
+		return p.compileCall(rule, name, t, elem)
+	}
+	if keys := indexKeys(rule.X); len(keys) > 0 {
+		return p.compileIndex(rule, keys, t, elem)
+	}
+	return elem(t)
+}
+
+// compileValue returns the function examining a value of type t with the rule. A value of
+// interface type is examined according to its dynamic type.
+func (p *RuleParser) compileValue(rule *ast.CmpExpr, t reflect.Type) (func(context.Context, reflect.Value) (bool, error), bool, error) {
+	if t.Kind() == reflect.Interface {
+		return p.compileDynamicValue(rule)
+	}
+	return p.compileRule(rule, t)
+}
+
+// compileDynamicValue returns the function examining a value, whose type is only known when the
+// context is examined, with the rule.
+func (p *RuleParser) compileDynamicValue(rule *ast.CmpExpr) (func(context.Context, reflect.Value) (bool, error), bool, error) {
+	if !isBasicOperation(rule.Op) && p.hasOperator(operationName(rule.Op)) {
+		return p.compileDynamicOperator(rule), false, nil
+	}
+
+	if isMatchOperation(rule.Op) {
+		return p.compileDynamicMatch(rule), true, nil
+	}
+
+	if rule.Op == "contains" {
+		fn, err := p.compileDynamicContains(rule)
+		return fn, true, err
+	}
+
+	if rule.Op == "has_key" {
+		return compileDynamicHasKey(rule), true, nil
+	}
+
+	if fn, err := compileDynamicString(rule); fn != nil || err != nil {
+		return fn, true, err
+	}
+
+	fn, err := p.compileDynamic(rule)
+	return fn, true, err
+}
+
+// compileDynamic returns the function comparing a value, whose type is only known when the
+// context is examined, with the literal.
+func (p *RuleParser) compileDynamic(rule *ast.CmpExpr) (func(context.Context, reflect.Value) (bool, error), error) {
+	if isSetOperation(rule.Op) {
+		return compileDynamicSet(rule)
+	}
+	if !isBasicOperation(rule.Op) {
+		return nil, typeError(rule, rule.Op+" is not available for "+rule.Operand())
+	}
+
+	op := GetBasicOperation(rule.Op)
+	timeCmp := p.timeCmp(rule.Y)
+	return func(_ context.Context, v reflect.Value) (bool, error) {
+		v, ok := dynamicValue(v)
+		if !ok {
+			return false, nilError("value", rule)
+		}
+		if v.Type() == numberType {
+			v = numberValue(json.Number(v.String()), rule.Y)
+		}
+		if v.Type() == timeType || v.Type() == durationType {
+			ret, err := timeCmp(v)
+			if err != nil {
+				return false, &TypeError{rule.Operand(), err.Error(), err}
+			}
+			return op(ret), nil
+		}
+		if !literalMatches(v.Kind(), rule.Y) {
+			return false, typeError(rule, rule.Y.Kind.String()+" literal "+rule.Y.Value+" does not match "+
+				v.Kind().String()+" value "+rule.Operand())
+		}
+		ret, err := cmpLiteral(v, rule.Y)
+		if err != nil {
+			return false, &TypeError{rule.Operand(), err.Error(), err}
+		}
+		return op(ret), nil
+	}, nil
+}
+
+// compileDynamicSet returns the function looking up a value, whose type is only known when the
+// context is examined, in the list of the rule.
+func compileDynamicSet(rule *ast.CmpExpr) (func(context.Context, reflect.Value) (bool, error), error) {
+	if rule.Y.Kind != ast.List {
+		return nil, typeError(rule, rule.Op+" of "+rule.Operand()+" expects a list of values, but "+
+			rule.Y.Kind.String()+" literal "+rule.Y.Value+" is found")
+	}
+
+	set := newValueSet(rule.Y)
+	in := rule.Op == "in"
+	return func(_ context.Context, v reflect.Value) (bool, error) {
+		v, ok := dynamicValue(v)
+		if !ok {
+			return false, nilError("value", rule)
+		}
+		if v.Type() == numberType {
+			v = numberValue(json.Number(v.String()), rule.Y)
+		}
+		if !set.matches(v.Kind()) {
+			return false, typeError(rule, "list "+ast.Format(rule.Y)+" does not match "+v.Kind().String()+
+				" value "+rule.Operand())
+		}
+		return set.contains(v) == in, nil
+	}, nil
+}
+
+// dynamicValue returns the value held by the interfaces and pointers. ok is false when nil is met.
+func dynamicValue(v reflect.Value) (reflect.Value, bool) {
+	for v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return v, false
+		}
+		v = v.Elem()
+	}
+	return v, v.IsValid()
+}
+
+// mapValue returns the entry of the map context keyed by the operand. An entry keyed by the
+// whole operand is preferred, otherwise each segment of a dotted operand is looked up in the map
+// the former segment leads to.
+func mapValue(v reflect.Value, operand string) (reflect.Value, bool) {
+	if x := mapIndex(v, operand); x.IsValid() {
+		return x, true
+	}
+
+	segments := strings.Split(operand, ".")
+	if len(segments) == 1 {
+		return reflect.Value{}, false
+	}
+
+	for _, seg := range segments {
+		v, _ = dynamicValue(v)
+		if v.Kind() != reflect.Map || v.Type().Key().Kind() != reflect.String {
+			return reflect.Value{}, false
+		}
+		if v = mapIndex(v, seg); !v.IsValid() {
+			return v, false
+		}
+	}
+	return v, true
+}
+
+func mapIndex(v reflect.Value, key string) reflect.Value {
+	return v.MapIndex(reflect.ValueOf(key).Convert(v.Type().Key()))
+}
+
+// checkKeys reports an error naming the operands of the rules which are not found in the map
+// context, as the strict mode requires.
+func (e *Evaluator) checkKeys(val reflect.Value) error {
+	var missing []string
+	for i, rule := range e.p.rules {
+		if e.rules[i] == nil || isPresenceRule(rule) || containsString(missing, rule.Operand()) {
+			continue
+		}
+		if _, ok := mapValue(val, rule.Operand()); !ok {
+			missing = append(missing, rule.Operand())
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return &OperandError{Type: e.typ, Operands: missing}
+}
@@ -0,0 +1,91 @@
+package parser
+
+import (
+	"context"
+	"github.com/kuangwanjing/ruleparser/ast"
+	"reflect"
+)
+
+// NilPolicy tells how a rule is examined when a nil pointer, interface or map is met on the way
+// to the value of its operand, or the value itself is nil. The rules examining the presence of
+// the value, such as `coupon exists` or `referrer is nil`, are not affected.
+type NilPolicy int
+
+const (
+	// NilError fails the examination with a *NilValueError. It is the default policy.
+	NilError NilPolicy = iota
+	// NilFalse fails the rule.
+	NilFalse
+	// NilSkip skips the rule, which passes like a rule whose operand is not bound to any field.
+	NilSkip
+)
+
+var nilPolicies = [...]string{
+	NilError: "error",
+	NilFalse: "false",
+	NilSkip:  "skip",
+}
+
+func (n NilPolicy) String() string {
+	if n < 0 || int(n) >= len(nilPolicies) {
+		return "unknown"
+	}
+	return nilPolicies[n]
+}
+
+// SetNilPolicy sets how the rules are examined when a nil value is met, see NilPolicy.
+func (p *RuleParser) SetNilPolicy(n NilPolicy) {
+	p.nilPolicy = n
+}
+
+// isPresenceRule tells whether the rule examines the presence of the value of its operand, as
+// `coupon exists`, `referrer is nil`, `referrer == nil` and `referrer != nil` do.
+func isPresenceRule(rule *ast.CmpExpr) bool {
+	return ast.IsUnaryOp(rule.Op) || rule.Y != nil && rule.Y.Kind == ast.Nil
+}
+
+// compilePresence returns the function telling whether the value is present, which is neither
+// nil nor invalid, for `exists` and `!=`, or absent for `is` and `==`. A nil pointer, interface,
+// map, slice, function or channel is absent.
+func compilePresence(rule *ast.CmpExpr) func(context.Context, reflect.Value) (bool, error) {
+	exists := rule.Op == "exists" || rule.Op == "!="
+	return func(_ context.Context, v reflect.Value) (bool, error) {
+		v, present := dynamicValue(v)
+		switch v.Kind() {
+		case reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+			present = !v.IsNil()
+		}
+		return present == exists, nil
+	}
+}
+
+// guardNil returns the function examining the value of the operand with the rule, which applies
+// the nil policy of the parser when the value is nil or a nil value is met on the way to it. The
+// policy is looked up on every examination since the cached evaluator is shared by all of them.
+// A skipped rule returns errSkip, so that it keeps passing under `!`.
+func (p *RuleParser) guardNil(rule *ast.CmpExpr, examine func(context.Context, reflect.Value) (bool, error)) func(
+	context.Context, reflect.Value) (bool, error) {
+	if isPresenceRule(rule) {
+		return examine
+	}
+
+	return func(ctx context.Context, v reflect.Value) (bool, error) {
+		var rst bool
+		var err error
+		if v.IsValid() {
+			rst, err = examine(ctx, v)
+		} else {
+			err = nilError("pointer", rule)
+		}
+
+		if _, ok := err.(*NilValueError); ok {
+			switch p.nilPolicy {
+			case NilFalse:
+				return false, nil
+			case NilSkip:
+				return false, errSkip
+			}
+		}
+		return rst, err
+	}
+}
@@ -0,0 +1,226 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"github.com/kuangwanjing/ruleparser/ast"
+	"go/token"
+	"reflect"
+	"sync"
+)
+
+// Operator is the signature of an operator accepting the values of every type, which is the one
+// the operators of the map contexts usually have. RegisterOperator accepts a function of other
+// signatures as well, while a function converted to Operator gets its signature checked by the
+// compiler. Like the operation methods of the fields, it returns 0 when the value satisfies the
+// operation.
+type Operator func(val interface{}, arg string) (int, error)
+
+// operator is a function registered for an operation.
+type operator struct {
+	fn          reflect.Value
+	in          reflect.Type // type of the value accepted
+	withContext bool         // whether a context.Context is accepted before the value
+}
+
+// operators are the operators registered for each operation, in the order they are registered.
+type operators map[string][]*operator
+
+var global = struct {
+	sync.RWMutex
+	ops operators
+}{ops: operators{}}
+
+// RegisterOperator registers the function as a global operator available to every parser. The
+// function has the same signature as an operation method except that the value examined comes
+// first, such as func(string, string) (int, error), optionally preceded by a context.Context.
+// A value of a basic data type is accepted by the function of the same kind, including the named
+// types like `type Platform string`, while a function accepting interface{} or another interface
+// accepts every value implementing it.
+//
+// An operator is used by the rules whose field has no method for the operation, so it can add
+// operations to the basic data types and to the types not owned by the caller. The operators of
+// a parser go before the global ones. The global operators must be registered before the parsers
+// examine any context, as the evaluators compiled earlier don't see them.
+func RegisterOperator(name string, fn interface{}) error {
+	op, err := newOperator(name, fn)
+	if err != nil {
+		return err
+	}
+	global.Lock()
+	defer global.Unlock()
+	global.ops.add(name, op)
+	return nil
+}
+
+// RegisterOperator registers the function as an operator of the parser, which goes before the
+// global operators, see the function RegisterOperator. A map holds no method, so the operations of
+// the map contexts other than the basic comparisons are examined by the operators only. The
+// operators must be registered before any context is examined, since the evaluators compiled so
+// far are dropped.
+func (p *RuleParser) RegisterOperator(name string, fn interface{}) error {
+	op, err := newOperator(name, fn)
+	if err != nil {
+		return err
+	}
+	if p.operators == nil {
+		p.operators = operators{}
+	}
+	p.operators.add(name, op)
+	p.plans.Range(func(k, _ interface{}) bool {
+		p.plans.Delete(k)
+		return true
+	})
+	return nil
+}
+
+func newOperator(name string, fn interface{}) (*operator, error) {
+	if !token.IsIdentifier(name) {
+		return nil, errors.New(name + " is not a valid name of operator")
+	}
+
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return nil, errors.New("operator " + name + " should be a function")
+	}
+
+	ft := v.Type()
+	withContext := ft.NumIn() == 3 && ft.In(0) == contextType
+	if ft.NumIn() != 2 && !withContext || ft.In(ft.NumIn()-1) != stringType || ft.NumOut() != 2 ||
+		ft.Out(0) != intType || ft.Out(1) != errorType || ft.IsVariadic() {
+		return nil, errors.New("operator " + name +
+			" should accept an optional context.Context, a value and a string and return an integer and an error object")
+	}
+
+	return &operator{v, ft.In(ft.NumIn() - 2), withContext}, nil
+}
+
+// add registers the operator, replacing the one of the same name accepting the same type.
+func (ops operators) add(name string, op *operator) {
+	for i, o := range ops[name] {
+		if o.in == op.in {
+			ops[name][i] = op
+			return
+		}
+	}
+	ops[name] = append(ops[name], op)
+}
+
+// find returns the operator of the name accepting a value of type t. The operator accepting
+// exactly the type is preferred, then the one of the same basic kind. The operators accepting an
+// interface are only found when iface is true.
+func (ops operators) find(name string, t reflect.Type, iface bool) *operator {
+	var found *operator
+	for _, o := range ops[name] {
+		switch {
+		case o.in == t:
+			return o
+		case iface:
+			if found == nil && o.in.Kind() == reflect.Interface && t.Implements(o.in) {
+				found = o
+			}
+		case found == nil && o.in.PkgPath() == "" && o.in.Name() == o.in.Kind().String() &&
+			o.in.Kind() == t.Kind():
+			// a predeclared type such as string, whose name is the name of its kind
+			found = o
+		}
+	}
+	return found
+}
+
+// operator returns the operator of the parser, or the global one, accepting a value of type t.
+func (p *RuleParser) operator(name string, t reflect.Type, iface bool) *operator {
+	if op := p.operators.find(name, t, iface); op != nil {
+		return op
+	}
+	global.RLock()
+	defer global.RUnlock()
+	return global.ops.find(name, t, iface)
+}
+
+// hasOperator tells whether any operator of the name is registered.
+func (p *RuleParser) hasOperator(name string) bool {
+	if len(p.operators[name]) > 0 {
+		return true
+	}
+	global.RLock()
+	defer global.RUnlock()
+	return len(global.ops[name]) > 0
+}
+
+// lookupOperator returns the operator accepting a field of type t, or the value t points to, and
+// the number of pointers to follow to get the value. The operators accepting the types go before
+// the ones accepting an interface, which are looked up from the value pointed to the deepest.
+func (p *RuleParser) lookupOperator(name string, t reflect.Type) (*operator, int) {
+	types := []reflect.Type{t}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+		types = append(types, t)
+	}
+
+	for derefs, t := range types {
+		if op := p.operator(name, t, false); op != nil {
+			return op, derefs
+		}
+	}
+	for derefs := len(types) - 1; derefs >= 0; derefs-- {
+		if op := p.operator(name, types[derefs], true); op != nil {
+			return op, derefs
+		}
+	}
+	return nil, 0
+}
+
+// call examines the value with the operator.
+func (o *operator) call(ctx context.Context, rule *ast.CmpExpr, v reflect.Value) (bool, error) {
+	if v.Type() != o.in && !v.Type().AssignableTo(o.in) {
+		v = v.Convert(o.in)
+	}
+	in := []reflect.Value{v, reflect.ValueOf(rule.Y.Value)}
+	if o.withContext {
+		in = []reflect.Value{reflect.ValueOf(&ctx).Elem(), v, reflect.ValueOf(rule.Y.Value)}
+	}
+	ret := o.fn.Call(in)
+	if err, _ := ret[1].Interface().(error); err != nil {
+		return false, err
+	}
+	return ret[0].Int() == 0, nil
+}
+
+// compileOperator returns the function examining a field of type t with the operator of the
+// rule, or nil when no operator accepts the field.
+func (p *RuleParser) compileOperator(rule *ast.CmpExpr, t reflect.Type) func(context.Context, reflect.Value) (bool, error) {
+	op, derefs := p.lookupOperator(operationName(rule.Op), t)
+	if op == nil {
+		return nil
+	}
+	return func(ctx context.Context, v reflect.Value) (bool, error) {
+		for i := 0; i < derefs; i++ {
+			if v.IsNil() {
+				return false, nilError("pointer", rule)
+			}
+			v = v.Elem()
+		}
+		return op.call(ctx, rule, v)
+	}
+}
+
+// compileDynamicOperator returns the function examining a value, whose type is only known when
+// the context is examined, with the operator of the rule accepting the dynamic type.
+func (p *RuleParser) compileDynamicOperator(rule *ast.CmpExpr) func(context.Context, reflect.Value) (bool, error) {
+	return func(ctx context.Context, v reflect.Value) (bool, error) {
+		v, ok := dynamicValue(v)
+		if !ok {
+			return false, nilError("value", rule)
+		}
+		name := operationName(rule.Op)
+		op := p.operator(name, v.Type(), false)
+		if op == nil {
+			op = p.operator(name, v.Type(), true)
+		}
+		if op == nil {
+			return false, typeError(rule, rule.Op+" is not available for "+v.Type().String()+" value "+rule.Operand())
+		}
+		return op.call(ctx, rule, v)
+	}
+}
@@ -0,0 +1,82 @@
+package parser
+
+import (
+	"context"
+	"fmt"
+	"github.com/kuangwanjing/ruleparser/ast"
+	"reflect"
+	"runtime/debug"
+)
+
+// PanicPolicy tells how a rule is examined when an operation method, a registered operator or
+// any other code of the application called by the rule panics. The panic is recovered in either
+// case, so it never crashes the process even if the rule is examined in its own goroutine.
+type PanicPolicy int
+
+const (
+	// FailClosed fails the examination with a *PanicError. It is the default policy.
+	FailClosed PanicPolicy = iota
+	// FailOpen skips the rule, which passes like a rule whose operand is not bound to any field.
+	FailOpen
+)
+
+var panicPolicies = [...]string{
+	FailClosed: "fail-closed",
+	FailOpen:   "fail-open",
+}
+
+func (pp PanicPolicy) String() string {
+	if pp < 0 || int(pp) >= len(panicPolicies) {
+		return "unknown"
+	}
+	return panicPolicies[pp]
+}
+
+// SetPanicPolicy sets how the rules are examined when they panic, see PanicPolicy.
+func (p *RuleParser) SetPanicPolicy(pp PanicPolicy) {
+	p.panicPolicy = pp
+}
+
+// PanicError reports a panic recovered when a rule is examined.
+type PanicError struct {
+	Operand   string      // operand of the rule
+	Operation string      // operation of the rule
+	Value     interface{} // value passed to panic
+	Stack     []byte      // stack trace of the goroutine where the panic is recovered
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic when examining %s with %s: %v", e.Operand, e.Operation, e.Value)
+}
+
+// Unwrap returns the value passed to panic when it is an error, such as a runtime.Error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// guard returns the function examining the value of the operand with the rule, which applies
+// the nil policy and the panic policy of the parser.
+func (p *RuleParser) guard(rule *ast.CmpExpr, examine func(context.Context, reflect.Value) (bool, error)) func(
+	context.Context, reflect.Value) (bool, error) {
+	return p.guardPanic(rule, p.guardNil(rule, examine))
+}
+
+// guardPanic returns the function examining the value with the rule, which recovers the panic
+// of the rule. The policy is looked up on every examination like the nil policy.
+func (p *RuleParser) guardPanic(rule *ast.CmpExpr, examine func(context.Context, reflect.Value) (bool, error)) func(
+	context.Context, reflect.Value) (bool, error) {
+	return func(ctx context.Context, v reflect.Value) (rst bool, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				if p.panicPolicy == FailOpen {
+					// the rule is skipped, so that it keeps passing under `!`
+					rst, err = false, errSkip
+					return
+				}
+				rst, err = false, &PanicError{rule.Operand(), rule.Op, r, debug.Stack()}
+			}
+		}()
+		return examine(ctx, v)
+	}
+}
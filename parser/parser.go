@@ -1,215 +1,168 @@
 package parser
 
 import (
-	"errors"
+	"context"
+	"github.com/kuangwanjing/ruleparser/ast"
 	"github.com/kuangwanjing/ruleparser/state"
-	"go/scanner"
 	"go/token"
-	"reflect"
+	"regexp"
+	"sync"
 	"time"
 )
 
 const tagName = "rule"
 
 type RuleParser struct {
-	rules     map[string][]state.RuleExpr
-	ruleCount int
-	timeout   time.Duration
+	root        ast.Expr
+	expr        *exprNode
+	rules       []*ast.CmpExpr       // rules in the order they appear
+	index       map[*ast.CmpExpr]int // position of a rule in rules
+	ruleCount   int
+	timeout     time.Duration
+	strict      bool
+	strategy    Strategy
+	workers     int
+	operators   operators                       // operators registered to the parser, see RegisterOperator
+	patterns    map[*ast.CmpExpr]*regexp.Regexp // compiled regular expressions of the rules matching them
+	clock       func() time.Time                // clock of the relative time literals, see SetClock
+	nilPolicy   NilPolicy                       // how the rules are examined when a nil value is met
+	panicPolicy PanicPolicy                     // how the rules are examined when they panic
+	plans       sync.Map                        // evaluators compiled for the types of context, see Compile
 }
 
 type RuleParserChannel struct {
-	rst bool
-	err error
+	rule int
+	rst  bool
+	err  error
 }
 
 func ParserInit(rules string) (*RuleParser, error) {
 	return rulesParser(rules)
 }
 
-func rulesParser(rules string) (*RuleParser, error) {
-	var exprs = make(map[string][]state.RuleExpr)
-	var count int = 0
+// ParseRules parses the rules into their syntax tree without building a parser, so that tools
+// can inspect or rewrite the rules.
+func ParseRules(rules string) (ast.Expr, error) {
+	var builder exprBuilder
 
 	// Initialize the scanner.
-	var s scanner.Scanner
-	fset := token.NewFileSet()                        // positions are relative to fset
-	file := fset.AddFile("", fset.Base(), len(rules)) // register input "file"
-	s.Init(file, []byte(rules), nil /* no error handler */, scanner.ScanComments)
+	s := newLexer(rules)
 
 	// Repeated calls to Scan yield the token sequence found in the input.
+	// The state machine validates every single rule while the logical operators and
+	// parentheses around the rules are handed to the expression builder.
 	var curState state.State = state.StateOperand{}
-	var exp state.RuleExpr
+	var exp = &ast.CmpExpr{}
+	var end token.Pos
 	for {
 		pos, tok, lit := s.Scan()
 		if tok == token.EOF {
+			end = pos
 			break
 		}
 
-		if reflect.TypeOf(curState).Name() == "StateOperand" {
-			exp = state.RuleExpr{}
+		newState, err := curState.Run(pos, tok, lit, exp)
+
+		if err != nil {
+			return nil, &SyntaxError{pos, err.Error(), err}
 		}
 
-		newState, err := curState.Run(pos, tok, lit, &exp)
+		switch curState.(type) {
+		case state.StateOperand:
+			if tok != token.IDENT {
+				err = builder.push(pos, tok)
+			}
+		case state.StateOperation, state.StateValue, state.StateElementEnd, state.StateCallEnd:
+			if _, ok := newState.(state.StateEnd); ok {
+				err = builder.rule(exp)
+				exp = &ast.CmpExpr{}
+			}
+		case state.StateEnd:
+			err = builder.push(pos, tok)
+		}
 
 		if err != nil {
 			return nil, err
 		}
 
-		if reflect.TypeOf(curState).Name() == "StateEnd" {
-			operand := exp.Operand
-			exprs[operand] = append(exprs[operand], exp)
-			count += 1
-		}
-
 		curState = newState
 	}
 
-	if len(exprs) == 0 {
-		return nil, errors.New("no rules to parse")
+	if _, ok := curState.(state.StateOperand); !ok {
+		return nil, syntaxError(end, "the last rule is not complete")
 	}
 
-	rp := &RuleParser{exprs, count, 500 * time.Millisecond}
-
-	return rp, nil
+	return builder.finish()
 }
 
-func (p *RuleParser) SetTimeout(t time.Duration) {
-	p.timeout = t
-}
-
-func (p *RuleParser) Examine(context interface{}) (bool, error) {
-	count := 0
-	ch := make(chan RuleParserChannel)
-
-	// testing whether context is a pointer. If it is, retrieve the element the pointer points to.
-	tmp := reflect.ValueOf(context)
-	ck := tmp.Kind()
-	if ck == reflect.Ptr || ck == reflect.Interface {
-		for ck == reflect.Ptr || ck == reflect.Interface {
-			tmp = tmp.Elem()
-			ck = tmp.Kind()
-		}
-		context = tmp.Interface()
-		ck = tmp.Kind()
+// ParserFromExpr builds a parser from the syntax tree of the rules, which is usually obtained
+// from ParseRules and possibly rewritten.
+func ParserFromExpr(root ast.Expr) (*RuleParser, error) {
+	if root == nil {
+		return nil, syntaxError(token.NoPos, "no rules to parse")
 	}
-
-	// since this method handles struct only, it's necessary to determine whether the context is of basic data type.
-	// if it is, return an error
-	if isBasicDataType(ck.String()) {
-		return false, errors.New(ck.String() + " is not accepted")
+	if err := checkExpr(root); err != nil {
+		return nil, err
 	}
 
-	t := reflect.TypeOf(context)
-	val := reflect.ValueOf(context)
-	for i := 0; i < t.NumField(); i++ {
-		field := t.Field(i)
-		tag := field.Tag.Get(tagName)
-		if tag == "" || tag == "-" {
-			continue
-		}
-		for _, rule := range p.rules[tag] {
-			fk := field.Type.Kind()
-			fv := val.Field(i)
-			go p.createExamineFn(rule, fk, fv, ch)()
-			count += 1
+	rules := ast.Rules(root)
+	index := make(map[*ast.CmpExpr]int)
+	for i, rule := range rules {
+		if _, ok := index[rule]; ok {
+			return nil, syntaxError(rule.Pos(), "rule %s appears more than once in the syntax tree", ast.Format(rule))
 		}
+		index[rule] = i
 	}
 
-	for i := 0; i < count; i++ {
-		select {
-		case rst := <-ch:
-			if !rst.rst || rst.err != nil {
-				return rst.rst, rst.err
-			}
-		case <-time.After(p.timeout):
-			return false, errors.New("timeout when parsing")
-		}
+	patterns, err := compilePatterns(rules)
+	if err != nil {
+		return nil, err
 	}
 
-	return true, nil
-}
-
-func (p *RuleParser) createExamineFn(rule state.RuleExpr,
-	kind reflect.Kind, value reflect.Value, ch chan RuleParserChannel) func() {
-
-	var k string
-
-	if kind == reflect.Ptr {
-		tv := value
-		tk := kind
-		for tk == reflect.Ptr {
-			tv = tv.Elem()
-			tk = tv.Kind()
-		}
-		k = tk.String()
-	} else {
-		k = kind.String()
+	rp := &RuleParser{
+		root:      root,
+		expr:      compileExpr(root, index),
+		rules:     rules,
+		index:     index,
+		patterns:  patterns,
+		ruleCount: len(rules),
+		timeout:   500 * time.Millisecond,
 	}
 
-	if !isBasicDataType(k) {
-		var fnName = ""
-		in := make([]reflect.Value, 1)
-		in[0] = reflect.ValueOf(rule.Value)
+	return rp, nil
+}
 
-		if isBasicOperation(rule.Operation) {
-			fnName = "Cmp"
-		} else {
-			// convert the first letter into upper case, so that the call is made towards an accessible method
-			fnName = ConvertOperationName(rule.Operation)
-		}
-		fn := value.MethodByName(fnName)
-		if !fn.IsValid() {
-			fnErr := errors.New(fnName + " function is not found for " + rule.Operand)
-			return func() {
-				ch <- RuleParserChannel{false, fnErr}
-			}
-		}
-		return func() {
-			ret := fn.Call(in)
-			retInt, err := p.getReturn(ret)
-			if err != nil {
-				ch <- RuleParserChannel{false, err}
-			}
-			if fnName == "Cmp" {
-				// basic comparison is built in the package
-				ch <- RuleParserChannel{GetBasicOperation(rule.Operation)(retInt), nil}
-			} else {
-				if retInt != 0 {
-					ch <- RuleParserChannel{false, nil}
-				} else {
-					ch <- RuleParserChannel{true, nil}
-				}
-			}
-		}
-	} else {
-		if !isBasicOperation(rule.Operation) || isUncomparableDataType(k) {
-			fnErr := errors.New(rule.Operation + " is not available for " + rule.Operand)
-			return func() {
-				ch <- RuleParserChannel{false, fnErr}
-			}
-		}
-		return func() {
-			retInt, err := BasicCmp(value.Interface(), rule.Value)
-			if err != nil {
-				ch <- RuleParserChannel{false, err}
-			}
-			ch <- RuleParserChannel{GetBasicOperation(rule.Operation)(retInt), nil}
-		}
+func rulesParser(rules string) (*RuleParser, error) {
+	root, err := ParseRules(rules)
+	if err != nil {
+		return nil, err
 	}
+	return ParserFromExpr(root)
+}
 
+// Expr returns the syntax tree of the rules examined by the parser. The tree is shared with
+// the parser and must not be modified.
+func (p *RuleParser) Expr() ast.Expr {
+	return p.root
 }
 
-func (p *RuleParser) getReturn(ret []reflect.Value) (int, error) {
-	if len(ret) != 2 || reflect.TypeOf(ret[0].Interface()).Kind() != reflect.Int {
-		return -1, errors.New("function should return an integer and an error object")
-	}
+// String returns the rules of the parser in their canonical form.
+func (p *RuleParser) String() string {
+	return ast.Format(p.root)
+}
 
-	retInt := ret[0].Interface().(int)
+func (p *RuleParser) SetTimeout(t time.Duration) {
+	p.timeout = t
+}
 
-	if ret[1].Interface() == nil {
-		return retInt, nil
-	} else {
-		retErr := ret[1].Interface().(error)
-		return retInt, retErr
-	}
+// SetStrict makes the parser fail the examination when any operand of the rules is not bound
+// to a field of the context, instead of skipping those rules.
+func (p *RuleParser) SetStrict(strict bool) {
+	p.strict = strict
+}
+
+// Examine examines the object, which is a struct or a pointer to a struct, with the rules. The
+// evaluator compiled for the type of the object is cached and reused by the later calls.
+func (p *RuleParser) Examine(obj interface{}) (bool, error) {
+	return p.ExamineContext(context.Background(), obj)
 }
@@ -1,9 +1,21 @@
 package parser
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/kuangwanjing/ruleparser/ast"
+	"go/token"
+	"math"
+	"reflect"
+	resyntax "regexp/syntax"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestRulesWithCorrectSyntax(t *testing.T) {
@@ -16,6 +28,16 @@ func TestRulesWithCorrectSyntax(t *testing.T) {
 		{"cat == `black,red`"},
 		{"a <= 10; b >= 100.3563247; x in `hello,world`"},
 		{"x==`10,10,5`;b!=true;t>=-3056"},
+		{"a < 1 || b > 2"},
+		{"!(a < 1) && b > 2; c == `x`"},
+		{"(a < 1 || !b == true) && !!(c >= 2.5 || (d != `y`))"},
+		{"device.os.version >= `14`; a.b in `x,y`"},
+		{"a in [`x`, `y`] && (b not_in (1, -2, 3.5) || c in [true])"},
+		{"any(tags) == `x` && all(a.b.scores) > 10 || len(tags)>=2; len > 1"},
+		{"labels[`env`] == `prod` && a.b[1][`c`] > 2 || !has_key(labels, `team`) && has_key(a.b[1], 2)"},
+		{"coupon exists && (referrer is nil || a.b != nil) && labels[`x`] exists; c == nil"},
+		{"platform == `ios`\n|| age > 100"},
+		{"(a < 1 ||\n  b > 2\n)\n\t&& c == `x`\n"},
 	}
 
 	for _, rule := range rules {
@@ -38,6 +60,42 @@ func TestRulesWithIncorrectSyntax(t *testing.T) {
 		{"a 10", "doesn't contain operation"},
 		{"a < 10, b > 100", "doesn't have semicolumn as a separator"},
 		{"a < 10; b in giergg", "doesn't have `` to quote the value"},
+		{"a < 1 ||", "doesn't have a rule after `||`"},
+		{"a < 1 && || b > 2", "contains two logical operators in a row"},
+		{"(a < 1 || b > 2", "doesn't close the parenthesis"},
+		{"a < 1) || b > 2", "doesn't open the parenthesis"},
+		{"()", "contains an empty group"},
+		{"!", "contains only negation"},
+		{"a < 1; b <", "doesn't complete the last rule"},
+		{"a. < 1", "doesn't have a selector after the period"},
+		{"a.b.5 < 1", "has a number as a selector"},
+		{"a in []", "contains an empty list"},
+		{"a in [1, 2", "doesn't close the list"},
+		{"a in [1, 2)", "closes the list with another bracket"},
+		{"a in [1,, 2]", "contains an empty element"},
+		{"a in [1 2]", "doesn't separate the elements with commas"},
+		{"a in [1, `x`]", "mixes the kinds of the elements"},
+		{"a in [b]", "has an identifier as an element"},
+		{"count(tags) > 1", "applies an unknown function"},
+		{"any() == `x`", "applies a function to nothing"},
+		{"any(tags == `x`", "doesn't close the function"},
+		{"any(tags).x == `x`", "selects from a function"},
+		{"len(tags)(x) > 1", "applies a function twice"},
+		{"any(len(tags)) > 1", "nests the functions"},
+		{"labels[env] == `x`", "indexes with an identifier"},
+		{"labels[1.5] == `x`", "indexes with a float"},
+		{"labels[`env` == `x`", "doesn't close the index"},
+		{"labels[`env`].name == `x`", "selects from an entry"},
+		{"any(tags)[`x`] == `x`", "indexes a function"},
+		{"has_key(labels) == `x`", "misses the key of has_key"},
+		{"has_key(labels, `x`", "doesn't close has_key"},
+		{"has_key(labels, `x`) == 1", "compares has_key"},
+		{"in(labels, `x`)", "calls an operation other than has_key"},
+		{"coupon exists `x`", "gives exists a value"},
+		{"coupon exists nil", "gives exists nil"},
+		{"coupon > nil", "compares nil with >"},
+		{"coupon in [nil]", "lists nil"},
+		{"coupon is null", "is an unknown identifier"},
 	}
 
 	for _, rule := range rules {
@@ -395,6 +453,1939 @@ func TestNonBasicOperationsForStruct(t *testing.T) {
 
 }
 
+func TestTypedLiterals(t *testing.T) {
+	tables := []struct {
+		rule string
+		kind ast.LitKind
+		val  interface{}
+	}{
+		{"a < 10", ast.Int, int64(10)},
+		{"a < 010", ast.Int, int64(10)},
+		{"a < 0x10", ast.Int, int64(16)},
+		{"a < -10", ast.Int, int64(-10)},
+		{"a<-10", ast.Int, int64(-10)},
+		{"a < - 10", ast.Int, int64(-10)},
+		{"a < 18446744073709551615", ast.Int, uint64(18446744073709551615)},
+		{"a < -2.5", ast.Float, float64(-2.5)},
+		{"a < 1e3", ast.Float, float64(1000)},
+		{"a == true", ast.Bool, true},
+		{"a == `10`", ast.String, "10"},
+	}
+
+	for _, table := range tables {
+		x, err := ParseRules(table.rule)
+
+		if err != nil {
+			t.Errorf("`%s` should be correct: %v", table.rule, err)
+			continue
+		}
+
+		lit := x.(*ast.CmpExpr).Y
+		if lit.Kind != table.kind || lit.Val != table.val {
+			t.Errorf("literal of `%s` should be %s %v, but %s %v is found", table.rule, table.kind, table.val, lit.Kind, lit.Val)
+		}
+	}
+
+	for _, rule := range []string{"a < -`x`", "a < - true", "a < 1e999", "a < 99999999999999999999"} {
+		if _, err := ParseRules(rule); err == nil {
+			t.Errorf("does not detect incorrect literal `%s`", rule)
+		}
+	}
+}
+
+func TestLiteralTypeMismatch(t *testing.T) {
+	type TestContext struct {
+		Age   int     `rule:"age"`
+		Score float64 `rule:"score"`
+		Name  string  `rule:"name"`
+		OK    bool    `rule:"ok"`
+		Size  uint    `rule:"size"`
+	}
+
+	context := TestContext{20, 3.5, "20", true, 10}
+
+	tables := []struct {
+		rules string
+		rst   bool
+		err   bool
+	}{
+		{"age == 20", true, false},
+		{"age == `20`", false, true},
+		{"age < 20.5", false, true},
+		{"age < 18446744073709551615", true, false},
+		{"score > 3", true, false},
+		{"score == `3.5`", false, true},
+		{"name == `20`", true, false},
+		{"name == 20", false, true},
+		{"ok == 1", true, false},
+		{"ok == 2", false, true},
+		{"ok == `true`", false, true},
+		{"size > -1", true, false},
+		{"size < 18446744073709551615", true, false},
+	}
+
+	for _, table := range tables {
+		p, err := ParserInit(table.rules)
+
+		if err != nil {
+			t.Errorf("error happens when initializing the parser with `%s`: %v", table.rules, err)
+			continue
+		}
+
+		rst, err := p.Examine(context)
+
+		if (err != nil) != table.err {
+			t.Errorf("error of rule `%s` should be %v, but %v is returned", table.rules, table.err, err)
+		} else if rst != table.rst {
+			t.Errorf("result of rule `%s` should be %v, but %v is returned", table.rules, table.rst, rst)
+		}
+	}
+}
+
+func TestLogicalOperations(t *testing.T) {
+	type TestContext struct {
+		Platform string `rule:"platform"`
+		Age      int    `rule:"age"`
+	}
+
+	tables := []struct {
+		context TestContext
+		rules   string
+		rst     bool
+	}{
+		{TestContext{"ios", 20}, "platform == `ios` || platform == `ipados`", true},
+		{TestContext{"ipados", 20}, "platform == `ios` || platform == `ipados`", true},
+		{TestContext{"android", 20}, "platform == `ios` || platform == `ipados`", false},
+		{TestContext{"ios", 20}, "platform == `ios` && age > 18", true},
+		{TestContext{"ios", 10}, "platform == `ios` && age > 18", false},
+		{TestContext{"ios", 10}, "!(age > 18)", true},
+		{TestContext{"ios", 10}, "!!(age > 18)", false},
+		{TestContext{"ios", 10}, "!age > 18 && platform == `ios`", true},
+		// `&&` binds tighter than `||`
+		{TestContext{"android", 10}, "platform == `ios` && age > 18 || age < 12", true},
+		{TestContext{"android", 10}, "platform == `ios` && (age > 18 || age < 12)", false},
+		// `;` binds looser than `||` so every rule separated by it must pass
+		{TestContext{"android", 10}, "platform == `ios` || age < 12; age > 18", false},
+		{TestContext{"android", 20}, "platform == `ios` || age < 12; age > 18", false},
+		{TestContext{"ios", 20}, "platform == `ios` || age < 12; age > 18", true},
+		{TestContext{"ipados", 20}, "(platform == `ios` || platform == `ipados`) && !(age < 18)", true},
+		{TestContext{"ipados", 10}, "(platform == `ios` || platform == `ipados`) && !(age < 18)", false},
+		// rules broken into lines, where a line not continued by `&&`, `||` or `)` ends like `;`
+		{TestContext{"ios", 20}, "platform == `ios`\n|| age > 100", true},
+		{TestContext{"android", 20}, "platform == `ios`\n|| age > 100", false},
+		{TestContext{"android", 20}, "(platform == `ios` ||\n  age > 18\n)\n&& age < 30", true},
+		{TestContext{"ios", 10}, "platform == `ios` || age > 18\nage > 18", false},
+		// rules not bound to the context pass
+		{TestContext{"ios", 20}, "unknown == 1 || age < 10", true},
+		// and keep passing when negated
+		{TestContext{"ios", 20}, "!(unknown == 1)", true},
+		{TestContext{"ios", 10}, "!(unknown == 1) && age > 18", false},
+		{TestContext{"ios", 10}, "!(unknown == 1 && age > 18)", true},
+		{TestContext{"ios", 20}, "!!(unknown == 1) && !(age < 18 || unknown == 2)", true},
+	}
+
+	for _, table := range tables {
+
+		p, err := ParserInit(table.rules)
+
+		if err != nil {
+			t.Errorf("error happens when initializing the parser with `%s`: %v", table.rules, err)
+			continue
+		}
+
+		for _, s := range []Strategy{Auto, Sequential} {
+			p.SetStrategy(s)
+			rst, err := p.Examine(table.context)
+
+			if err != nil {
+				t.Errorf("error happens when running the parser with `%s` (%v): %v", table.rules, s, err)
+				continue
+			}
+
+			if rst != table.rst {
+				t.Errorf("result of rule `%s` (%v) should be %v, but %v is returned", table.rules, s, table.rst, rst)
+			}
+		}
+	}
+}
+
+func TestParserFromIncorrectExpr(t *testing.T) {
+	rule := &ast.CmpExpr{X: &ast.Ident{Name: "a"}, Op: "<", Y: &ast.BasicLit{Kind: ast.Int, Value: "1", Val: int64(1)}}
+
+	tables := []struct {
+		expr ast.Expr
+		msg  string
+	}{
+		{nil, "empty syntax tree"},
+		{&ast.CmpExpr{X: &ast.Ident{Name: "a"}, Op: "<"}, "rule without value"},
+		{&ast.CmpExpr{Op: "<", Y: &ast.BasicLit{Kind: ast.Int, Value: "1", Val: int64(1)}}, "rule without operand"},
+		{&ast.BinaryExpr{X: rule, Op: token.ADD, Y: rule}, "binary operator other than `&&`, `||` and `;`"},
+		{&ast.BinaryExpr{X: rule, Op: token.LAND}, "binary operator without right operand"},
+		{&ast.BinaryExpr{X: rule, Op: token.LAND, Y: rule}, "the same rule appearing twice"},
+		{&ast.UnaryExpr{Op: token.NOT, X: &ast.Ident{Name: "a"}}, "negation of an operand"},
+		{&ast.CmpExpr{X: &ast.Ident{Name: "a"}, Op: "<", Y: &ast.BasicLit{Kind: ast.Int, Value: "1"}}, "literal without typed value"},
+		{&ast.CmpExpr{X: &ast.Ident{Name: "a"}, Op: "<", Y: &ast.BasicLit{Kind: ast.Int, Value: "1", Val: int64(2)}}, "literal with another value"},
+	}
+
+	for _, table := range tables {
+		_, err := ParserFromExpr(table.expr)
+
+		if err == nil {
+			t.Errorf("does not detect incorrect syntax tree: %s", table.msg)
+		}
+	}
+}
+
+type TypeT5 struct {
+	t int
+}
+
+func (t TypeT5) Cmp(val int) (int, error) {
+	return t.t - val, nil
+}
+
+func TestCompile(t *testing.T) {
+	type TestContext struct {
+		Age  int    `rule:"age"`
+		T    TypeT  `rule:"t"`
+		Name string `rule:"name"`
+	}
+
+	p, err := ParserInit("age > 18 && t < 30; name != `x`")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := p.Compile(reflect.TypeOf(&TestContext{}))
+
+	if err != nil {
+		t.Fatalf("error happens when compiling the parser: %v", err)
+	}
+
+	if e.Type() != reflect.TypeOf(TestContext{}) {
+		t.Errorf("evaluator should be compiled for TestContext, but %v is found", e.Type())
+	}
+
+	tables := []struct {
+		context TestContext
+		rst     bool
+	}{
+		{TestContext{20, TypeT{20}, "a"}, true},
+		{TestContext{10, TypeT{20}, "a"}, false},
+		{TestContext{20, TypeT{40}, "a"}, false},
+		{TestContext{20, TypeT{20}, "x"}, false},
+	}
+
+	for _, table := range tables {
+		rst, err := e.Examine(table.context)
+		if err != nil || rst != table.rst {
+			t.Errorf("result of %v should be %v, but (%v, %v) is returned", table.context, table.rst, rst, err)
+		}
+		rst, err = e.Examine(&table.context)
+		if err != nil || rst != table.rst {
+			t.Errorf("result of &%v should be %v, but (%v, %v) is returned", table.context, table.rst, rst, err)
+		}
+	}
+
+	if _, err := e.Examine(TestContext1{}); err == nil {
+		t.Error("error should happen when examining a context of another type")
+	}
+
+	// the evaluator is compiled once for each type of context
+	e1, _ := p.evaluator(reflect.TypeOf(TestContext{}))
+	e2, _ := p.evaluator(reflect.TypeOf(TestContext{}))
+	if e1 == nil || e1 != e2 {
+		t.Error("evaluator should be cached for the type of context")
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	type TestContext struct {
+		T TypeT5 `rule:"t"`
+	}
+
+	p, err := ParserInit("t < 30")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tables := []struct {
+		t   reflect.Type
+		msg string
+	}{
+		{nil, "nil type"},
+		{reflect.TypeOf(10), "int context"},
+		{reflect.TypeOf([]TestContext{}), "slice context"},
+		{reflect.TypeOf(TestContext{}), "Cmp method with incorrect signature"},
+		{reflect.TypeOf(TestContext4{}), "missing Cmp method"},
+	}
+
+	for _, table := range tables {
+		if _, err := p.Compile(table.t); err == nil {
+			t.Errorf("error should happen when compiling with %s", table.msg)
+		}
+	}
+
+	var nilContext *TestContext1
+	if _, err := p.Examine(nilContext); err == nil {
+		t.Error("error should happen when examining a nil pointer")
+	}
+	if _, err := p.Examine(nil); err == nil {
+		t.Error("error should happen when examining nil")
+	}
+}
+
+func TestStrictOperands(t *testing.T) {
+	type TestContext struct {
+		Platform string `rule:"platform"`
+		Version  string `rule:"version"`
+	}
+
+	p, err := ParserInit("platfrom == `android` || platform == `ios`; versoin > `1.0`; platfrom != `x`")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the rules with a typo pass silently unless the parser is strict
+	rst, err := p.Examine(TestContext{"web", "2.0"})
+	if err != nil || !rst {
+		t.Errorf("unbound rules should pass when the parser is not strict, but (%v, %v) is returned", rst, err)
+	}
+
+	err = p.CheckOperands(reflect.TypeOf(&TestContext{}))
+	if err == nil || !strings.Contains(err.Error(), "platfrom, versoin") {
+		t.Errorf("error should name the unbound operands, but %v is returned", err)
+	}
+
+	e, _ := p.Compile(reflect.TypeOf(TestContext{}))
+	if u := e.Unbound(); len(u) != 2 || u[0] != "platfrom" || u[1] != "versoin" {
+		t.Errorf("unbound operands should be platfrom and versoin, but %v is returned", u)
+	}
+
+	p.SetStrict(true)
+
+	if _, err := p.Examine(TestContext{"web", "2.0"}); err == nil {
+		t.Error("error should happen when examining unbound rules with a strict parser")
+	}
+
+	if _, err := p.Compile(reflect.TypeOf(TestContext{})); err == nil {
+		t.Error("error should happen when compiling unbound rules with a strict parser")
+	}
+
+	p.SetStrict(false)
+
+	if _, err := p.Examine(TestContext{"web", "2.0"}); err != nil {
+		t.Errorf("no error should happen after the parser is not strict anymore, but %v is returned", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	type TestContext struct {
+		Age   int      `rule:"age"`
+		Tags  []string `rule:"tags"`
+		T     TypeT    `rule:"t"`
+		T4    TypeT4   `rule:"t4"`
+		T5    TypeT5   `rule:"t5"`
+		Score float64  `rule:"score"`
+	}
+
+	p, err := ParserInit("age > 18; tags > 1; t in `1,2`; t4 < 3; t5 < 3; score == `high`; nmae == `x`; t < 20")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = p.Validate(reflect.TypeOf(TestContext{}))
+
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("*ValidationError should be returned, but %v is returned", err)
+	}
+
+	// unbound operands, slice field, missing In, missing Cmp, incorrect Cmp signature and literal mismatch
+	if len(verr.Errors) != 6 {
+		t.Errorf("6 problems should be found, but %d are found: %v", len(verr.Errors), err)
+	}
+
+	for _, msg := range []string{"nmae", "tags", "In", "t4", "t5", "high"} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("problem about %s is not reported: %v", msg, err)
+		}
+	}
+
+	p, _ = ParserInit("age > 18; t < 20 || score >= 2")
+
+	if err := p.Validate(reflect.TypeOf(&TestContext{})); err != nil {
+		t.Errorf("rules should be valid, but %v is returned", err)
+	}
+
+	if err := p.Validate(reflect.TypeOf(10)); err == nil {
+		t.Error("error should happen when validating against int")
+	}
+}
+
+func TestExplain(t *testing.T) {
+	type TestContext struct {
+		Platform string `rule:"platform"`
+		Age      int    `rule:"age"`
+		T        TypeT  `rule:"t"`
+	}
+
+	p, err := ParserInit("(platform == `ios` || platform == `android`) && age >= 18; t < 10; unknown == 1")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	x, err := p.Explain(&TestContext{"android", 20, TypeT{15}})
+
+	if err != nil {
+		t.Fatalf("error happens when explaining: %v", err)
+	}
+
+	if x.Result || x.Err != nil {
+		t.Errorf("result should be false without error, but (%v, %v) is returned", x.Result, x.Err)
+	}
+
+	expected := []struct {
+		operand string
+		bound   bool
+		actual  interface{}
+		rst     bool
+	}{
+		{"platform", true, "android", false},
+		{"platform", true, "android", true},
+		{"age", true, 20, true},
+		{"t", true, TypeT{15}, false},
+		{"unknown", false, nil, true},
+	}
+
+	if len(x.Rules) != len(expected) {
+		t.Fatalf("%d rules should be explained, but %d are found", len(expected), len(x.Rules))
+	}
+
+	for i, r := range x.Rules {
+		exp := expected[i]
+		if r.Operand != exp.operand || r.Bound != exp.bound || r.Actual != exp.actual || r.Result != exp.rst || r.Err != nil {
+			t.Errorf("rule %d should be %v, but %+v is found", i, exp, r)
+		}
+	}
+
+	if !strings.Contains(x.String(), "t < 10: false, actual: {15}") {
+		t.Errorf("explanation is not formatted correctly: %s", x.String())
+	}
+
+	p, _ = ParserInit("age >= 18 || t == `x`")
+	x, err = p.Explain(TestContext{"ios", 20, TypeT{15}})
+
+	// the error of a rule is reported for the rule, but the result doesn't depend on it
+	if err != nil || !x.Result || x.Err != nil || x.Rules[1].Err == nil || x.Rules[0].Err != nil {
+		t.Errorf("error of the second rule should be reported, but %v is returned", x)
+	}
+
+	p, _ = ParserInit("age >= 18 && t == `x`")
+	x, err = p.Explain(TestContext{"ios", 20, TypeT{15}})
+	rst, rerr := p.Examine(TestContext{"ios", 20, TypeT{15}})
+
+	if err != nil || x.Result != rst || x.Err == nil || x.Err != x.Rules[1].Err || rerr == nil {
+		t.Errorf("error of the second rule should fail the result, but %v is returned", x)
+	}
+
+	if _, err = p.Explain(10); err == nil {
+		t.Error("error should happen when explaining an int context")
+	}
+}
+
+type ctxKey struct{}
+
+type Remote struct {
+	cancelled chan struct{}
+}
+
+// Check simulates a remote check which waits for its context unless the value is "fast".
+func (r Remote) Check(ctx context.Context, val string) (int, error) {
+	if val == "fast" {
+		if ctx.Value(ctxKey{}) != "traced" {
+			return -1, nil
+		}
+		return 0, nil
+	}
+	<-ctx.Done()
+	if r.cancelled != nil {
+		close(r.cancelled)
+	}
+	return -1, ctx.Err()
+}
+
+func TestExamineContext(t *testing.T) {
+	type TestContext struct {
+		R   Remote `rule:"r"`
+		Age int    `rule:"age"`
+	}
+
+	// the context is handed to the method
+	p, _ := ParserInit("r check `fast`")
+	ctx := context.WithValue(context.Background(), ctxKey{}, "traced")
+	rst, err := p.ExamineContext(ctx, TestContext{Remote{}, 20})
+	if err != nil || !rst {
+		t.Errorf("context should be handed to the method, but (%v, %v) is returned", rst, err)
+	}
+
+	p, _ = ParserInit("r check `slow`")
+
+	// cancellation of the caller
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := make(chan struct{})
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	_, err = p.ExamineContext(ctx, TestContext{Remote{cancelled}, 20})
+	if err != context.Canceled {
+		t.Errorf("context.Canceled should be returned, but %v is returned", err)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Error("cancellation should be propagated to the method")
+	}
+
+	// deadline of the caller
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = p.ExamineContext(ctx, TestContext{Remote{make(chan struct{})}, 20})
+	if err != context.DeadlineExceeded {
+		t.Errorf("context.DeadlineExceeded should be returned, but %v is returned", err)
+	}
+
+	// timeout of the parser
+	p.SetTimeout(10 * time.Millisecond)
+	cancelled = make(chan struct{})
+	_, err = p.ExamineContext(context.Background(), TestContext{Remote{cancelled}, 20})
+	if err == nil || err == context.DeadlineExceeded {
+		t.Errorf("timeout of the parser should be returned, but %v is returned", err)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Error("timeout of the parser should be propagated to the method")
+	}
+
+	// a cancelled context is not examined at all
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	p, _ = ParserInit("age > 10")
+	if _, err = p.ExamineContext(ctx, TestContext{Remote{}, 20}); err != context.Canceled {
+		t.Errorf("context.Canceled should be returned, but %v is returned", err)
+	}
+
+	// the rules which are not finished are explained with the error of the context
+	p, _ = ParserInit("age > 10; r check `slow`")
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	x, err := p.ExplainContext(ctx, TestContext{Remote{make(chan struct{})}, 20})
+	if err != nil || x.Rules[0].Err != nil || !x.Rules[0].Result || x.Rules[1].Err != context.DeadlineExceeded {
+		t.Errorf("deadline should be reported for the unfinished rule, but %v is returned", x)
+	}
+}
+
+func TestNoGoroutineLeak(t *testing.T) {
+	type TestContext struct {
+		R   Remote `rule:"r"`
+		T   TypeT  `rule:"t"`
+		Age int    `rule:"age"`
+	}
+
+	obj := TestContext{Remote{}, TypeT{10}, 20}
+	baseline := runtime.NumGoroutine()
+
+	tables := []string{
+		"age > 30; r check `slow`",                      // early exit on a false rule
+		"age > 10 || r check `slow`",                    // early exit on a true rule
+		"t == `x`; r check `slow`",                      // early exit on an error
+		"r check `slow`; r check `slow`",                // timeout of the parser
+		"age > 10; age < 30; t == 10; t < 20; age != 1", // no early exit
+	}
+
+	for _, strategy := range []Strategy{Auto, Sequential, WorkerPool, FanOut} {
+		for _, rules := range tables {
+			p, err := ParserInit(rules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Explain waits for the slow rules until the timeout
+			p.SetTimeout(5 * time.Millisecond)
+			p.SetStrategy(strategy)
+			p.SetWorkers(1)
+
+			for i := 0; i < 10; i++ {
+				p.Examine(obj)
+				p.Explain(obj)
+			}
+		}
+	}
+
+	// the goroutines of the rules which are still running terminate once they are cancelled
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > baseline && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if n := runtime.NumGoroutine(); n > baseline {
+		t.Errorf("%d goroutines are leaked", n-baseline)
+	}
+}
+
+type Counter struct {
+	calls   *int32
+	running *int32
+	max     *int32
+}
+
+// Cmp compares the pattern with 5 and records the number of calls and of concurrent calls.
+func (c Counter) Cmp(val string) (int, error) {
+	atomic.AddInt32(c.calls, 1)
+	n := atomic.AddInt32(c.running, 1)
+	for {
+		m := atomic.LoadInt32(c.max)
+		if n <= m || atomic.CompareAndSwapInt32(c.max, m, n) {
+			break
+		}
+	}
+	time.Sleep(time.Millisecond)
+	atomic.AddInt32(c.running, -1)
+	i, err := strconv.Atoi(val)
+	return 5 - i, err
+}
+
+func TestStrategies(t *testing.T) {
+	type TestContext struct {
+		Platform string  `rule:"platform"`
+		Age      int     `rule:"age"`
+		C        Counter `rule:"c"`
+	}
+
+	tables := []struct {
+		rules string
+		rst   bool
+		calls int32 // calls of Cmp made by the sequential strategy
+	}{
+		{"platform == `ios` || c > 1", true, 0},
+		{"platform == `android` || c > 1", true, 1},
+		{"age > 18 && c > 1 && c > 2", false, 0},
+		{"c > 10; c > 1; c > 2", false, 1},
+		{"!(c > 1) || c < 10 && age < 18", true, 2},
+		{"c > 1 && c > 2 && c > 3 && c > 4 && c < 10 && c < 11", true, 6},
+	}
+
+	for _, strategy := range []Strategy{Auto, Sequential, WorkerPool, FanOut} {
+		for _, table := range tables {
+			p, err := ParserInit(table.rules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p.SetStrategy(strategy)
+			p.SetWorkers(2)
+
+			var calls, running, max int32
+			rst, err := p.Examine(TestContext{"ios", 10, Counter{&calls, &running, &max}})
+
+			if err != nil || rst != table.rst {
+				t.Errorf("result of rule `%s` with %s strategy should be %v, but (%v, %v) is returned",
+					table.rules, strategy, table.rst, rst, err)
+			}
+
+			// the concurrent strategies may return while some calls are still running
+			if n := atomic.LoadInt32(&calls); strategy == Sequential && n != table.calls {
+				t.Errorf("rule `%s` with %s strategy should call Cmp %d times, but %d calls are made",
+					table.rules, strategy, table.calls, n)
+			}
+
+			if n := atomic.LoadInt32(&max); strategy == WorkerPool && n > 2 {
+				t.Errorf("rule `%s` with %s strategy should call Cmp with 2 goroutines at most, but %d are found",
+					table.rules, strategy, n)
+			}
+
+			x, err := p.Explain(TestContext{"ios", 10, Counter{&calls, &running, &max}})
+			if err != nil || x.Result != table.rst {
+				t.Errorf("explanation of rule `%s` with %s strategy should be %v, but (%v, %v) is returned",
+					table.rules, strategy, table.rst, x, err)
+			}
+		}
+	}
+}
+
+func TestStrategiesWithErrors(t *testing.T) {
+	type TestContext struct {
+		Platform string  `rule:"platform"`
+		Age      int     `rule:"age"`
+		C        Counter `rule:"c"`
+	}
+
+	// Cmp fails with the pattern x, which fails the examination only when the result depends on it
+	tables := []struct {
+		rules string
+		rst   bool
+		err   bool
+	}{
+		{"age < 18 || c > `x`", false, true},
+		{"age >= 18 || c > `x`", true, false},
+		{"c > `x` || age >= 18", true, false},
+		{"c > `x` && age < 18", false, false},
+		{"c > `x` && age >= 18", false, true},
+		{"!(c > `x`) || platform == `ios`", true, false},
+		{"c > 10 && c > `x`", false, false},
+		{"c > `x`; c > 1", false, true},
+	}
+
+	for _, table := range tables {
+		p, err := ParserInit(table.rules)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, strategy := range []Strategy{Auto, Sequential, WorkerPool, FanOut} {
+			p.SetStrategy(strategy)
+			var calls, running, max int32
+			rst, err := p.Examine(TestContext{"ios", 20, Counter{&calls, &running, &max}})
+
+			if (err != nil) != table.err || rst != table.rst {
+				t.Errorf("rule `%s` with %s strategy should return (%v, error %v), but (%v, %v) is returned",
+					table.rules, strategy, table.rst, table.err, rst, err)
+			}
+
+			x, err := p.Explain(TestContext{"ios", 20, Counter{&calls, &running, &max}})
+			if err != nil || (x.Err != nil) != table.err || x.Result != table.rst {
+				t.Errorf("explanation of rule `%s` with %s strategy should be (%v, error %v), but %v is returned",
+					table.rules, strategy, table.rst, table.err, x)
+			}
+		}
+	}
+}
+
+func TestStrategiesWithTimeout(t *testing.T) {
+	type TestContext struct {
+		Remote Remote `rule:"remote"`
+		Age    int    `rule:"age"`
+	}
+
+	// the slow check doesn't finish in time and fails with the timeout, which fails the
+	// examination only when the result depends on it
+	tables := []struct {
+		rules string
+		rst   bool
+		err   error
+	}{
+		{"remote check `slow` || age > 18", true, nil},
+		{"age > 18 || remote check `slow`", true, nil},
+		{"remote check `slow` && age < 18", false, nil},
+		{"remote check `slow` && age > 18", false, ErrTimeout},
+		{"!(remote check `slow`) || remote check `fast`", false, ErrTimeout},
+	}
+
+	for _, table := range tables {
+		p, err := ParserInit(table.rules)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.SetTimeout(10 * time.Millisecond)
+		p.SetWorkers(1)
+
+		for _, strategy := range []Strategy{Auto, Sequential, WorkerPool, FanOut} {
+			p.SetStrategy(strategy)
+			rst, err := p.Examine(TestContext{Age: 20})
+
+			if err != table.err || rst != table.rst {
+				t.Errorf("rule `%s` with %s strategy should return (%v, %v), but (%v, %v) is returned",
+					table.rules, strategy, table.rst, table.err, rst, err)
+			}
+
+			// Explain resolves in the same way, keeping the rules finished before the timeout
+			for i := 0; i < 5; i++ {
+				x, err := p.Explain(TestContext{Age: 20})
+				if err != nil || x.Err != table.err || x.Result != table.rst {
+					t.Errorf("explanation of rule `%s` with %s strategy should be (%v, %v), but %v is returned",
+						table.rules, strategy, table.rst, table.err, x)
+					break
+				}
+				for _, r := range x.Rules {
+					if r.Operand == "age" && (r.Err != nil || r.Result != (r.Operation == ">")) {
+						t.Errorf("rule `%s` with %s strategy should be reported without error, but %+v is found",
+							ast.Format(r.Rule), strategy, r)
+					}
+				}
+			}
+		}
+	}
+}
+
+type OS struct {
+	Name    string `rule:"name"`
+	Version TypeT  `rule:"version"`
+}
+
+type Device struct {
+	OS    *OS    `rule:"os"`
+	Model string `rule:"model"`
+}
+
+func TestNestedOperands(t *testing.T) {
+	type TestContext struct {
+		Device   Device  `rule:"device"`
+		Backup   *Device `rule:"backup"`
+		Platform string  `rule:"device.model"` // the whole operand takes precedence
+		Age      int     `rule:"age"`
+	}
+
+	context := TestContext{
+		Device:   Device{&OS{"ios", TypeT{14}}, "iphone"},
+		Backup:   &Device{&OS{"android", TypeT{9}}, "pixel"},
+		Platform: "ipad",
+	}
+
+	tables := []struct {
+		rules string
+		rst   bool
+	}{
+		{"device.os.name == `ios`", true},
+		{"device.os.version >= 14 && device.os.version < 15", true},
+		{"backup.os.name == `android` && backup.model == `pixel`", true},
+		{"device.model == `ipad`", true},
+		{"device.os.name == `android` || backup.os.version > 10", false},
+	}
+
+	for _, table := range tables {
+		p, err := ParserInit(table.rules)
+
+		if err != nil {
+			t.Errorf("error happens when initializing the parser with `%s`: %v", table.rules, err)
+			continue
+		}
+
+		p.SetStrict(true)
+		rst, err := p.Examine(&context)
+
+		if err != nil || rst != table.rst {
+			t.Errorf("result of rule `%s` should be %v, but (%v, %v) is returned", table.rules, table.rst, rst, err)
+		}
+	}
+
+	p, _ := ParserInit("device.os.nmae == `ios`; device.model.x == 1; age.x > 1")
+	if err := p.CheckOperands(reflect.TypeOf(context)); err == nil ||
+		!strings.Contains(err.Error(), "device.os.nmae, device.model.x, age.x") {
+		t.Errorf("unbound nested operands should be reported, but %v is returned", err)
+	}
+
+	// a nil pointer on the way to the field
+	p, _ = ParserInit("backup.os.name == `android`")
+	context.Backup.OS = nil
+	if _, err := p.Examine(context); err == nil {
+		t.Error("error should happen when a nil pointer is met on the way to the field")
+	}
+	context.Backup = nil
+	if _, err := p.Examine(context); err == nil {
+		t.Error("error should happen when a nil pointer is met on the way to the field")
+	}
+}
+
+type ClientInfo struct {
+	Platform string `rule:"platform"`
+	Version  TypeT  `rule:"version"`
+	Channel  string `rule:"channel"`
+}
+
+type DeviceInfo struct {
+	Model   string `rule:"model"`
+	Channel string `rule:"channel"`
+}
+
+type Inner struct {
+	ClientInfo
+}
+
+type session struct {
+	Token string `rule:"token"`
+	level int    `rule:"level"`
+}
+
+func TestSetOperations(t *testing.T) {
+	type TestContext struct {
+		Name  string   `rule:"name"`
+		Plat  Platform `rule:"platform"`
+		Age   int8     `rule:"age"`
+		Big   uint64   `rule:"big"`
+		Score float32  `rule:"score"`
+		VIP   bool     `rule:"vip"`
+		Level *int     `rule:"level"`
+		City  City     `rule:"city"`
+		Tags  []string `rule:"tags"`
+	}
+
+	level := 3
+	ctx := TestContext{"jack", "ios", -5, math.MaxUint64, 2.5, true, &level, City{"b"}, nil}
+
+	tables := []struct {
+		rules string
+		rst   bool
+		err   bool
+	}{
+		{"name in [`jack`, `rose`] && platform in (`ios`, `android`)", true, false},
+		{"name not_in [`rose`] && platform not_in [`ios`]", false, false},
+		{"age in (-5, 0, 5) && age not_in [5]", true, false},
+		{"big in [18446744073709551615] && big not_in [1, 2]", true, false},
+		{"score in [1, 2.5] && score not_in [2]", true, false},
+		{"vip in [true] && vip not_in [0]", true, false},
+		{"level in [1, 2, 3]", true, false},
+		{"city in [`a`, `b`]", true, false},
+		{"name in `jack`", false, true},
+		{"name in [1, 2]", false, true},
+		{"age in [1.5]", false, true},
+		{"vip in [2]", false, true},
+		{"name == [`jack`]", false, true},
+		{"tags in [`a`]", false, true},
+	}
+
+	for _, table := range tables {
+		p, err := ParserInit(table.rules)
+
+		if err != nil {
+			t.Errorf("error happens when initializing the parser with `%s`: %v", table.rules, err)
+			continue
+		}
+
+		rst, err := p.Examine(&ctx)
+
+		if table.err {
+			if err == nil {
+				t.Errorf("error should happen when examining with rule `%s`", table.rules)
+			}
+		} else if err != nil || rst != table.rst {
+			t.Errorf("result of rule `%s` should be %v, but (%v, %v) is returned", table.rules, table.rst, rst, err)
+		}
+	}
+
+	// the values of maps and JSON documents
+	p, _ := ParserInit("name in [`jack`] && id in [9007199254740993] && score not_in [1, 2]")
+	if rst, err := p.ExamineJSON([]byte(`{"name":"jack","id":9007199254740993,"score":2.5}`)); err != nil || !rst {
+		t.Errorf("JSON document should pass the rules, but (%v, %v) is returned", rst, err)
+	}
+	if _, err := p.Examine(map[string]interface{}{"name": 1}); err == nil {
+		t.Error("error should happen when the value doesn't match the list")
+	}
+}
+
+type Email struct {
+	user, domain string
+}
+
+func (e Email) String() string {
+	return e.user + "@" + e.domain
+}
+
+type Pattern string
+
+func (p Pattern) Matches(val string) (int, error) {
+	if string(p) == val {
+		return 0, nil
+	}
+	return 1, nil
+}
+
+func TestMatchOperation(t *testing.T) {
+	type TestContext struct {
+		UA      string   `rule:"ua"`
+		Plat    Platform `rule:"platform"`
+		Email   Email    `rule:"email"`
+		Backup  *Email   `rule:"backup"`
+		Pattern Pattern  `rule:"pattern"`
+		Age     int      `rule:"age"`
+	}
+
+	ctx := TestContext{"Mozilla/5.0 (iPhone)", "ios", Email{"jack", "example.com"}, nil, "^x$", 20}
+
+	tables := []struct {
+		rules string
+		rst   bool
+		err   bool
+	}{
+		{"ua matches `iPhone|iPad` && platform =~ `^i`", true, false},
+		{"ua=~`^Android` || platform matches `^and`", false, false},
+		{"email =~ `@example\\.com$` && !(email =~ `^rose`)", true, false},
+		{"pattern matches `^x$`", true, false},
+		{"backup =~ `x`", false, true},
+		{"age matches `2`", false, true},
+	}
+
+	for _, table := range tables {
+		p, err := ParserInit(table.rules)
+
+		if err != nil {
+			t.Errorf("error happens when initializing the parser with `%s`: %v", table.rules, err)
+			continue
+		}
+
+		rst, err := p.Examine(&ctx)
+
+		if table.err {
+			if err == nil {
+				t.Errorf("error should happen when examining with rule `%s`", table.rules)
+			}
+		} else if err != nil || rst != table.rst {
+			t.Errorf("result of rule `%s` should be %v, but (%v, %v) is returned", table.rules, table.rst, rst, err)
+		}
+	}
+
+	for _, rules := range []string{"ua matches `[a`", "ua =~ 1", "ua = ~ `x`", "ua ~ `x`", "ua = `x`"} {
+		if _, err := ParserInit(rules); err == nil {
+			t.Errorf("error should happen when initializing the parser with `%s`", rules)
+		}
+	}
+
+	p, _ := ParserInit("ua =~ `iPhone` && name matches `^j`")
+	if rst, err := p.Examine(map[string]interface{}{"ua": ctx.UA, "name": Email{"jack", "x"}}); err != nil || !rst {
+		t.Errorf("map context should pass the rules, but (%v, %v) is returned", rst, err)
+	}
+	if _, err := p.Examine(map[string]interface{}{"ua": 1}); err == nil {
+		t.Error("error should happen when matching a number")
+	}
+}
+
+func TestStringOperations(t *testing.T) {
+	type TestContext struct {
+		Name  string   `rule:"name"`
+		Plat  Platform `rule:"platform"`
+		Title *string  `rule:"title"`
+		Age   int      `rule:"age"`
+		Empty *string  `rule:"empty"`
+	}
+
+	title := "Straße in München"
+	ctx := TestContext{"Jack Sparrow", "iOS", &title, 20, nil}
+
+	tables := []struct {
+		rules string
+		rst   bool
+		err   bool
+	}{
+		{"name contains `Spar` && name startsWith `Jack` && name endsWith `row`", true, false},
+		{"name contains `spar` || name startsWith `Sparrow` || name endsWith `Jack`", false, false},
+		{"name eqi `JACK SPARROW` && platform eqi `ios` && platform startsWith `i`", true, false},
+		{"title eqi `STRASSE IN MÜNCHEN`", false, false},
+		{"title eqi `STRAßE IN MÜNCHEN` && title contains `ü`", true, false},
+		{"name contains 1", false, true},
+		{"age contains `2`", false, true},
+		{"empty eqi `x`", false, true},
+	}
+
+	for _, table := range tables {
+		p, err := ParserInit(table.rules)
+
+		if err != nil {
+			t.Errorf("error happens when initializing the parser with `%s`: %v", table.rules, err)
+			continue
+		}
+
+		rst, err := p.Examine(&ctx)
+
+		if table.err {
+			if err == nil {
+				t.Errorf("error should happen when examining with rule `%s`", table.rules)
+			}
+		} else if err != nil || rst != table.rst {
+			t.Errorf("result of rule `%s` should be %v, but (%v, %v) is returned", table.rules, table.rst, rst, err)
+		}
+	}
+
+	p, _ := ParserInit("name eqi `ΣΊΣΥΦΟΣ` && lang startsWith `en`")
+	if rst, err := p.Examine(map[string]string{"name": "σίσυφος", "lang": "en-US"}); err != nil || !rst {
+		t.Errorf("map context should pass the rules, but (%v, %v) is returned", rst, err)
+	}
+	if _, err := p.Examine(map[string]interface{}{"name": 1}); err == nil {
+		t.Error("error should happen when the value is not a string")
+	}
+}
+
+func TestSemver(t *testing.T) {
+	type TestContext struct {
+		Ver  string `rule:"ver,semver"`
+		Name string `rule:"name"`
+	}
+
+	// in the order of precedence
+	versions := []string{
+		"0.9.10", "1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta", "1.0.0-beta.2",
+		"1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "1.2.3", "1.10.0", "2.0.0", "18446744073709551616.0.0",
+	}
+
+	for i, a := range versions {
+		for j, b := range versions {
+			for _, op := range basicOperations {
+				// versions written without quotes are compared by semantic versioning even for a
+				// field without the option
+				for _, rules := range []string{"ver " + op + " `" + b + "`", "ver " + op + " " + b, "name " + op + " v" + b} {
+					p, err := ParserInit(rules)
+					if err != nil {
+						t.Fatalf("error happens when initializing the parser with `%s`: %v", rules, err)
+					}
+					rst, err := p.Examine(TestContext{a, a})
+					if expected := GetBasicOperation(op)(cmpInt64(int64(i), int64(j))); err != nil || rst != expected {
+						t.Errorf("`%s` should be %v for %s, but (%v, %v) is returned", rules, expected, a, rst, err)
+					}
+				}
+			}
+		}
+	}
+
+	// invalid versions written without quotes are reported when the rules are parsed
+	invalid := []struct {
+		rules string
+		pos   token.Pos
+	}{
+		{"ver < 01.3.5", 7},
+		{"ver < 1.3.5-01", 7},
+		{"ver < 1.3.5+a..b", 7},
+		{"ver > 1.0.0 && ver < v1.3.5-", 22},
+		{"ver in [1.3.5, 1.3.5-x.01]", 8},
+		{"ver < 1.3.5+\n|| ver > 2.0.0.", 7},
+	}
+	for _, table := range invalid {
+		var serr *SyntaxError
+		if _, err := ParserInit(table.rules); !errors.As(err, &serr) || serr.Pos != table.pos {
+			t.Errorf("syntax error at %d should be returned for `%s`, but %v is returned", table.pos, table.rules, err)
+		}
+	}
+
+	p, _ := ParserInit("app >= 1.3.0 && app < v2.0.0-rc.1 && ver == 1.3.5")
+	if f := p.String(); f != "app >= 1.3.0 && app < v2.0.0-rc.1 && ver == 1.3.5" {
+		t.Errorf("versions should be formatted as they are written, but `%s` is returned", f)
+	}
+	doc := []byte(`{"app":"1.10.0","ver":"v1.3.5+build.1"}`)
+	if rst, err := p.ExamineJSON(doc); err != nil || !rst {
+		t.Errorf("JSON document should pass the rules, but (%v, %v) is returned", rst, err)
+	}
+	if _, err := p.Examine(map[string]interface{}{"app": "1.10", "ver": "1.3.5"}); !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("invalid version of the map should be reported, but %v is returned", err)
+	}
+
+	tables := []struct {
+		ver   string
+		rules string
+		rst   bool
+		err   bool
+	}{
+		{"v1.3.5+build.7", "ver == `1.3.5+build.1` && ver > `1.3.5-rc.1+x` && ver < `v1.3.10`", true, false},
+		{"1.3.5", "ver < `1.3`", false, true},
+		{"1.3.5", "ver < `01.3.5`", false, true},
+		{"1.3.5", "ver < `1.3.5-01`", false, true},
+		{"1.3.5", "ver < `1.3.5-`", false, true},
+		{"1.3.5", "ver < `1.3.5+a..b`", false, true},
+		{"1.3.5", "ver < `1.3.x`", false, true},
+		{"1.3.5", "ver < 1", false, true},
+		{"1.3.5", "ver in [`1.3.5`]", false, true},
+		{"1.3", "ver < `1.3.5`", false, true},
+	}
+
+	for _, table := range tables {
+		p, err := ParserInit(table.rules)
+
+		if err != nil {
+			t.Errorf("error happens when initializing the parser with `%s`: %v", table.rules, err)
+			continue
+		}
+
+		rst, err := p.Examine(TestContext{table.ver, ""})
+
+		if table.err {
+			if err == nil {
+				t.Errorf("error should happen when examining %s with rule `%s`", table.ver, table.rules)
+			}
+		} else if err != nil || rst != table.rst {
+			t.Errorf("result of rule `%s` should be %v, but (%v, %v) is returned", table.rules, table.rst, rst, err)
+		}
+	}
+
+	type BadContext struct {
+		Ver int `rule:"ver,semver"`
+	}
+	p, _ = ParserInit("ver < `1.3.5` || ver < 1.3.5")
+	if err := p.Validate(reflect.TypeOf(BadContext{})); err == nil {
+		t.Error("error should happen when the semantic version is not a string field")
+	}
+}
+
+func TestTimeOperations(t *testing.T) {
+	type TestContext struct {
+		Release  time.Time      `rule:"release"`
+		Login    *time.Time     `rule:"login"`
+		Timeout  time.Duration  `rule:"timeout"`
+		Interval *time.Duration `rule:"interval"`
+		Never    *time.Time     `rule:"never"`
+	}
+
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	login := now.Add(-36 * time.Hour)
+	interval := 90 * time.Minute
+	ctx := TestContext{time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC), &login, 3 * time.Second, &interval, nil}
+
+	tables := []struct {
+		rules string
+		rst   bool
+		err   bool
+	}{
+		{"release >= `2024-01-01` && release < `2024-01-01T09:00:00+01:00`", false, false},
+		{"release > `2024-01-01` && release == `2024-01-01T09:00:00+01:00`", true, false},
+		{"release == `2024-01-01 08:00:00` && release != `2024-01-01T08:00:00.5Z`", true, false},
+		{"login > `now-7d` && login < `now-1d` && login < `now` && login == `now-1d12h`", true, false},
+		{"login >= `now-1w` && release < `now+1h` && release < `now-9w`", true, false},
+		{"timeout < `5s` && timeout >= 3000000000 && interval == `1h30m` && interval < `0.1d`", true, false},
+		{"timeout > `-1m` && timeout < `1d`", true, false},
+		{"release > `yesterday`", false, true},
+		{"release > `now7d`", false, true},
+		{"release > 20240101", false, true},
+		{"timeout < `5 seconds`", false, true},
+		{"timeout < `d`", false, true},
+		{"timeout < 5.5", false, true},
+		{"never > `now`", false, true},
+	}
+
+	for _, table := range tables {
+		p, err := ParserInit(table.rules)
+
+		if err != nil {
+			t.Errorf("error happens when initializing the parser with `%s`: %v", table.rules, err)
+			continue
+		}
+
+		p.SetClock(func() time.Time {
+			return now
+		})
+
+		rst, err := p.Examine(&ctx)
+
+		if table.err {
+			if err == nil {
+				t.Errorf("error should happen when examining with rule `%s`", table.rules)
+			}
+		} else if err != nil || rst != table.rst {
+			t.Errorf("result of rule `%s` should be %v, but (%v, %v) is returned", table.rules, table.rst, rst, err)
+		}
+	}
+
+	// the clock is read on every examination
+	p, _ := ParserInit("login > `now-2d`")
+	p.SetClock(func() time.Time {
+		return now
+	})
+	if rst, _ := p.Examine(&ctx); !rst {
+		t.Error("login should be within 2 days")
+	}
+	p.SetClock(func() time.Time {
+		return now.Add(24 * time.Hour)
+	})
+	if rst, _ := p.Examine(&ctx); rst {
+		t.Error("login should not be within 2 days a day later")
+	}
+
+	p, _ = ParserInit("at > `2024-01-01` && ttl <= `1m`")
+	if rst, err := p.Examine(map[string]interface{}{"at": now, "ttl": time.Minute}); err != nil || !rst {
+		t.Errorf("map context should pass the rules, but (%v, %v) is returned", rst, err)
+	}
+
+	if ret, err := BasicCmp(now, "2024-03-10T12:00:00Z"); err != nil || ret != 0 {
+		t.Errorf("time should be equal, but (%v, %v) is returned", ret, err)
+	}
+	if ret, err := BasicCmp(time.Hour, "59m"); err != nil || ret != 1 {
+		t.Errorf("duration should be greater, but (%v, %v) is returned", ret, err)
+	}
+}
+
+type Tags []string
+
+func (tags Tags) Contains(val string) (int, error) {
+	for _, tag := range tags {
+		if strings.EqualFold(tag, val) {
+			return 0, nil
+		}
+	}
+	return 1, nil
+}
+
+func TestCollections(t *testing.T) {
+	type Device struct {
+		Tags []string `rule:"tags"`
+	}
+
+	type TestContext struct {
+		Tags     []string          `rule:"tags"`
+		Scores   [3]int            `rule:"scores"`
+		Versions []TypeT           `rule:"versions"`
+		Pointers *[]*float64       `rule:"pointers"`
+		Items    []interface{}     `rule:"items"`
+		Labels   map[string]string `rule:"labels"`
+		Name     string            `rule:"name"`
+		Named    Tags              `rule:"named"`
+		Device   Device            `rule:"device"`
+		Empty    []int             `rule:"empty"`
+		Vers     []string          `rule:"vers,semver"`
+		Age      int               `rule:"age"`
+	}
+
+	f1, f2 := 1.5, 2.5
+	pointers := []*float64{&f1, &f2}
+	ctx := TestContext{
+		Tags:     []string{"sports", "live"},
+		Scores:   [3]int{12, 15, 20},
+		Versions: []TypeT{{3}, {14}},
+		Pointers: &pointers,
+		Items:    []interface{}{"a", 1.5, true},
+		Labels:   map[string]string{"env": "prod"},
+		Name:     "jack",
+		Named:    Tags{"Live"},
+		Device:   Device{[]string{"ios"}},
+		Vers:     []string{"1.2.0", "1.10.0"},
+	}
+
+	tables := []struct {
+		rules string
+		rst   bool
+		err   bool
+	}{
+		{"tags contains `live` && !(tags contains `pets`) && any(tags) == `sports`", true, false},
+		{"all(scores) > 10 && any(scores) >= 20 && all(scores) in [12, 15, 20]", true, false},
+		{"all(scores) > 12 || any(scores) < 12", false, false},
+		{"any(versions) >= 14 && all(versions) > 2 && all(versions) < 14", false, false},
+		{"all(pointers) < 3 && any(pointers) == 1.5 && len(pointers) == 2", true, false},
+		{"items contains `a` && any(items) == `a` && len(items) == 3", true, false},
+		{"any(tags) =~ `^sp` && all(tags) =~ `^[a-z]+$` && any(tags) eqi `LIVE` && any(tags) startsWith `li`", true, false},
+		{"len(tags) >= 2 && len(scores) == 3 && len(labels) == 1 && len(name) == 4", true, false},
+		{"named contains `live` && any(device.tags) == `ios` && len(device.tags) > 0", true, false},
+		{"all(empty) > 100 && !(any(empty) > 100) && len(empty) == 0 && !(empty contains 1)", true, false},
+		{"all(vers) >= `1.2.0` && any(vers) > `1.9.0`", true, false},
+		{"any(items) > 1", false, true},
+		{"any(tags) == 1", false, true},
+		{"len(tags) == `2`", false, true},
+		{"any(age) == 1", false, true},
+		{"len(age) == 1", false, true},
+		{"tags == `live`", false, true},
+	}
+
+	for _, table := range tables {
+		p, err := ParserInit(table.rules)
+
+		if err != nil {
+			t.Errorf("error happens when initializing the parser with `%s`: %v", table.rules, err)
+			continue
+		}
+
+		rst, err := p.Examine(&ctx)
+
+		if table.err {
+			if err == nil {
+				t.Errorf("error should happen when examining with rule `%s`", table.rules)
+			}
+		} else if err != nil || rst != table.rst {
+			t.Errorf("result of rule `%s` should be %v, but (%v, %v) is returned", table.rules, table.rst, rst, err)
+		}
+	}
+
+	p, _ := ParserInit("any(tags) == `live` && tags contains `sports` && len(tags) == 2 && all(owner.scores) > 3")
+	doc := []byte(`{"tags":["sports","live"],"owner":{"scores":[4, 5.5]}}`)
+	if rst, err := p.ExamineJSON(doc); err != nil || !rst {
+		t.Errorf("JSON document should pass the rules, but (%v, %v) is returned", rst, err)
+	}
+	p, _ = ParserInit("any(tags) == `live`")
+	if _, err := p.Examine(map[string]interface{}{"tags": "sports"}); err == nil {
+		t.Error("error should happen when any is applied to a string")
+	}
+}
+
+func TestMapFields(t *testing.T) {
+	type Meta struct {
+		Labels map[string]string `rule:"labels"`
+	}
+
+	type TestContext struct {
+		Labels     map[string]string      `rule:"labels"`
+		Attributes map[string]interface{} `rule:"attributes"`
+		Levels     *map[int8]TypeT        `rule:"levels"`
+		Versions   map[string]string      `rule:"versions,semver"`
+		Meta       *Meta                  `rule:"meta"`
+		Empty      map[string]int         `rule:"empty"`
+		Name       string                 `rule:"name"`
+	}
+
+	levels := map[int8]TypeT{1: {5}}
+	ctx := TestContext{
+		Labels: map[string]string{"env": "prod", "team": "core"},
+		Attributes: map[string]interface{}{
+			"score": 12,
+			"geo":   map[string]interface{}{"country": "NL"},
+			"tags":  []string{"a", "b"},
+		},
+		Levels:   &levels,
+		Versions: map[string]string{"app": "1.10.0"},
+		Meta:     &Meta{map[string]string{"owner": "jack"}},
+	}
+
+	tables := []struct {
+		rules  string
+		rst    bool
+		err    bool
+		strict bool
+	}{
+		{"labels[`env`] == `prod` && labels[`team`] startsWith `co` && labels[`env`] in [`prod`, `dev`]", true, false, false},
+		{"attributes[`score`] > 10 && attributes[`geo`][`country`] == `NL` && attributes[`tags`] contains `b`", true, false, false},
+		{"levels[1] > 3 && versions[`app`] > `1.9.0` && meta.labels[`owner`] eqi `JACK`", true, false, false},
+		{"has_key(labels, `team`) && !has_key(labels, `owner`) && has_key(attributes[`geo`], `country`)", true, false, false},
+		{"labels has_key `env` && has_key(meta.labels, `owner`) && !has_key(empty, `x`)", true, false, false},
+		{"labels[`owner`] == `jack`", false, false, false},
+		{"labels[`owner`] != `jack`", false, false, false},
+		{"!(labels[`owner`] == `jack`) && empty[`x`] < 1", false, false, false},
+		{"attributes[`geo`][`city`] == `x` || attributes[`none`][`city`] == `x`", false, false, false},
+		{"labels[`owner`] == `jack`", false, true, true},
+		{"attributes[`geo`][`city`] == `x`", false, true, true},
+		{"labels[`env`] == 1", false, true, false},
+		{"labels[1] == `prod`", false, true, false},
+		{"levels[`1`] > 3", false, true, false},
+		{"levels[128] > 3", false, true, false},
+		{"name[`x`] == `y`", false, true, false},
+		{"attributes[`score`][`x`] == 1", false, true, false},
+		{"has_key(name, `x`)", false, true, false},
+		{"has_key(levels, `x`)", false, true, false},
+		{"labels == `x`", false, true, false},
+	}
+
+	for _, table := range tables {
+		p, err := ParserInit(table.rules)
+
+		if err != nil {
+			t.Errorf("error happens when initializing the parser with `%s`: %v", table.rules, err)
+			continue
+		}
+		p.SetStrict(table.strict)
+
+		rst, err := p.Examine(&ctx)
+
+		if table.err {
+			if err == nil {
+				t.Errorf("error should happen when examining with rule `%s`", table.rules)
+			}
+		} else if err != nil || rst != table.rst {
+			t.Errorf("result of rule `%s` should be %v, but (%v, %v) is returned", table.rules, table.rst, rst, err)
+		}
+	}
+
+	p, _ := ParserInit("attributes[`geo`][`country`] == `NL` && has_key(attributes, `geo`) && attributes[`tags`][`x`] == 1")
+	doc := []byte(`{"attributes":{"geo":{"country":"NL"},"tags":{}}}`)
+	if rst, err := p.ExamineJSON(doc); err != nil || rst {
+		t.Errorf("JSON document should fail the rules, but (%v, %v) is returned", rst, err)
+	}
+	if _, err := p.Examine(map[string]interface{}{"attributes": "x"}); err == nil {
+		t.Error("error should happen when a string is indexed")
+	}
+}
+
+func TestNilValues(t *testing.T) {
+	type Coupon struct {
+		Code string `rule:"code"`
+	}
+
+	type TestContext struct {
+		Coupon   *Coupon           `rule:"coupon"`
+		Referrer *string           `rule:"referrer"`
+		Ver      *TypeT            `rule:"ver"`
+		City     *City             `rule:"city"`
+		Extra    interface{}       `rule:"extra"`
+		Tags     []string          `rule:"tags"`
+		Scores   *[]int            `rule:"scores"`
+		Labels   map[string]string `rule:"labels"`
+		Age      int               `rule:"age"`
+	}
+
+	referrer := "jack"
+	ctx := TestContext{Referrer: &referrer, Labels: map[string]string{"env": "prod"}, Age: 1}
+
+	presence := []struct {
+		rules string
+		rst   bool
+	}{
+		{"coupon is nil && referrer exists && ver == nil && city is nil && extra is nil && tags is nil", true},
+		{"coupon exists || referrer == nil || ver != nil || extra exists || tags exists", false},
+		{"coupon.code exists || coupon.code != nil || !(coupon.code is nil)", false},
+		{"age exists && labels exists && labels[`env`] exists && labels[`team`] is nil", true},
+		{"labels[`team`] exists || has_key(labels, `team`)", false},
+	}
+
+	for _, table := range presence {
+		p, err := ParserInit(table.rules)
+		if err != nil {
+			t.Errorf("error happens when initializing the parser with `%s`: %v", table.rules, err)
+			continue
+		}
+		// the presence is examined in the same way whatever the nil policy is
+		for _, policy := range []NilPolicy{NilError, NilFalse, NilSkip} {
+			p.SetNilPolicy(policy)
+			p.SetStrict(policy == NilError)
+			if rst, err := p.Examine(&ctx); err != nil || rst != table.rst {
+				t.Errorf("result of rule `%s` with the %v policy should be %v, but (%v, %v) is returned",
+					table.rules, policy, table.rst, rst, err)
+			}
+		}
+	}
+
+	rules := []string{
+		"ver > 3",
+		"city in `sf,la`",
+		"coupon.code == `x`",
+		"referrer == `jack` && extra == 1",
+		"any(scores) > 1",
+		"referrer startsWith `j` && coupon.code =~ `^x`",
+	}
+
+	for _, rule := range rules {
+		p, err := ParserInit(rule)
+		if err != nil {
+			t.Errorf("error happens when initializing the parser with `%s`: %v", rule, err)
+			continue
+		}
+
+		if _, err := p.Examine(&ctx); err == nil || !strings.Contains(err.Error(), "nil") {
+			t.Errorf("error about nil should happen when examining with rule `%s`, but %v is returned", rule, err)
+		}
+
+		p.SetNilPolicy(NilFalse)
+		if rst, err := p.Examine(&ctx); err != nil || rst {
+			t.Errorf("rule `%s` should fail with the false policy, but (%v, %v) is returned", rule, rst, err)
+		}
+
+		p.SetNilPolicy(NilSkip)
+		if rst, err := p.Examine(&ctx); err != nil || !rst {
+			t.Errorf("rule `%s` should pass with the skip policy, but (%v, %v) is returned", rule, rst, err)
+		}
+
+		// a skipped rule passes even when negated
+		negated := "!(" + rule + ")"
+		p, _ = ParserInit(negated)
+		p.SetNilPolicy(NilSkip)
+		for _, strategy := range []Strategy{Auto, Sequential, FanOut} {
+			p.SetStrategy(strategy)
+			if rst, err := p.Examine(&ctx); err != nil || !rst {
+				t.Errorf("rule `%s` should pass with the skip policy and %s strategy, but (%v, %v) is returned",
+					negated, strategy, rst, err)
+			}
+		}
+		if x, err := p.Explain(&ctx); err != nil || !x.Result || x.Err != nil || !x.Rules[0].Result {
+			t.Errorf("explanation of `%s` with the skip policy is not expected: %v, %v", negated, x, err)
+		}
+	}
+
+	p, _ := ParserInit("coupon exists || extra == 1")
+	p.SetNilPolicy(NilFalse)
+	x, err := p.Explain(&ctx)
+	if err != nil || x.Result || x.Err != nil || x.Rules[0].Value != "" {
+		t.Errorf("explanation of `%s` is not expected: %v, %v", p, x, err)
+	}
+
+	// the missing keys of a map context are absent even for a strict parser
+	p, _ = ParserInit("!(owner exists) && !(coupon exists) && coupon is nil && user.name == nil && score exists")
+	p.SetStrict(true)
+	doc := []byte(`{"user":{"name":null},"score":0,"coupon":null}`)
+	if rst, err := p.ExamineJSON(doc); err != nil || !rst {
+		t.Errorf("JSON document should pass the rules, but (%v, %v) is returned", rst, err)
+	}
+
+	p, _ = ParserInit("any(tags) exists")
+	if err := p.Validate(reflect.TypeOf(ctx)); err == nil {
+		t.Error("error should happen when a function is applied to the operand of exists")
+	}
+}
+
+type Faulty struct {
+	Name string
+}
+
+func (f Faulty) Cmp(val string) (int, error) {
+	panic("faulty comparison")
+}
+
+func (f *Faulty) In(val string) (int, error) {
+	// a nil map is written to
+	var m map[string]int
+	m[val] = len(f.Name)
+	return 0, nil
+}
+
+func TestPanicRecovery(t *testing.T) {
+	type TestContext struct {
+		Faulty Faulty      `rule:"faulty"`
+		Ptr    *Faulty     `rule:"ptr"`
+		Level  int         `rule:"level"`
+		Extra  interface{} `rule:"extra"`
+	}
+
+	odd := func(i int, val string) (int, error) {
+		panic(errors.New("faulty operator"))
+	}
+
+	ctx := TestContext{Ptr: &Faulty{"x"}, Level: 3, Extra: 5}
+	tables := []struct {
+		rules     string
+		operand   string
+		operation string
+	}{
+		{"faulty == `x`", "faulty", "=="},
+		{"ptr in `a,b`", "ptr", "in"},
+		{"level odd `x`", "level", "odd"},
+		{"extra odd `x`", "extra", "odd"},
+		{"level > 1 && faulty < `x`", "faulty", "<"},
+	}
+
+	for _, table := range tables {
+		for _, s := range []Strategy{Auto, Sequential, WorkerPool, FanOut} {
+			p, err := ParserInit(table.rules)
+			if err != nil {
+				t.Fatalf("error happens when initializing the parser with `%s`: %v", table.rules, err)
+			}
+			if err := p.RegisterOperator("odd", odd); err != nil {
+				t.Fatal(err)
+			}
+			p.SetStrategy(s)
+
+			var perr *PanicError
+			rst, err := p.Examine(&ctx)
+			if !errors.As(err, &perr) || rst {
+				t.Errorf("rule `%s` with the %v strategy should fail with a panic error, but (%v, %v) is returned",
+					table.rules, s, rst, err)
+				continue
+			}
+			if perr.Operand != table.operand || perr.Operation != table.operation || len(perr.Stack) == 0 {
+				t.Errorf("panic error of rule `%s` is not expected: %#v", table.rules, perr)
+			}
+
+			p.SetPanicPolicy(FailOpen)
+			if rst, err := p.Examine(&ctx); err != nil || !rst {
+				t.Errorf("rule `%s` with the %v strategy should pass when failing open, but (%v, %v) is returned",
+					table.rules, s, rst, err)
+			}
+
+			// the rule failing open still passes when negated
+			negated := "!(" + table.rules + ")"
+			n, _ := ParserInit(negated)
+			n.RegisterOperator("odd", odd)
+			n.SetStrategy(s)
+			n.SetPanicPolicy(FailOpen)
+			if rst, err := n.Examine(&ctx); err != nil || !rst {
+				t.Errorf("rule `%s` with the %v strategy should pass when failing open, but (%v, %v) is returned",
+					negated, s, rst, err)
+			}
+		}
+	}
+
+	q, _ := ParserInit("ptr in `a`")
+	_, err := q.Examine(&ctx)
+	var rerr runtime.Error
+	if !errors.As(err, &rerr) || !strings.Contains(err.Error(), "ptr with in") {
+		t.Errorf("runtime error should be wrapped in the panic error, but %v is returned", err)
+	}
+	var perr *PanicError
+	if errors.As(err, &perr) && !strings.Contains(string(perr.Stack), "Faulty).In") {
+		t.Errorf("stack should lead to the method panicking, but it is\n%s", perr.Stack)
+	}
+
+	x, err := q.Explain(&ctx)
+	if err != nil || !errors.As(x.Err, &perr) {
+		t.Errorf("explanation should report the panic error, but (%v, %v) is returned", x, err)
+	}
+}
+
+func TestTypedErrors(t *testing.T) {
+	type TestContext struct {
+		Ver     TypeT             `rule:"ver"`
+		City    City2             `rule:"city"`
+		Remote  Remote            `rule:"remote"`
+		Age     int               `rule:"age"`
+		App     string            `rule:"app,semver"`
+		Labels  map[string]string `rule:"labels"`
+		Pointer *TypeT3           `rule:"pointer"`
+	}
+	ctx := TestContext{Ver: TypeT{3}, App: "1.2.3", Labels: map[string]string{}}
+
+	syntax := []struct {
+		rules string
+		pos   token.Pos
+	}{
+		{"a < 1 && b", 11},
+		{"a < 1 && b <", 13},
+		{"a ! 1", 3},
+		{"(a < 1", 1},
+		{"a < 1)", 6},
+		{"a =~ `[x`", 6},
+		{"a < 1.3.05", 5},
+		{"", token.NoPos},
+	}
+	for _, table := range syntax {
+		_, err := ParserInit(table.rules)
+		var serr *SyntaxError
+		if !errors.Is(err, ErrSyntax) || !errors.As(err, &serr) || serr.Pos != table.pos || serr.Error() != serr.Msg {
+			t.Errorf("syntax error at %d should be returned for `%s`, but %#v is returned", table.pos, table.rules, err)
+		}
+	}
+
+	// the cause of the syntax error is kept
+	var rerr *resyntax.Error
+	if _, err := ParserInit("a =~ `[x`"); !errors.As(err, &rerr) || rerr.Code != resyntax.ErrMissingBracket {
+		t.Errorf("syntax error should wrap the error of the regular expression, but %v is returned", err)
+	}
+	for _, rules := range []string{"a < 1 && b", "a ! 1", "a < 1.3.05", "a[true] == 1"} {
+		if _, err := ParserInit(rules); errors.Unwrap(err) == nil {
+			t.Errorf("syntax error of `%s` should wrap its cause, but %#v is returned", rules, err)
+		}
+	}
+
+	root, _ := ParseRules("a < 1")
+	root.(*ast.CmpExpr).Op = ""
+	if _, err := ParserFromExpr(root); !errors.Is(err, ErrSyntax) {
+		t.Errorf("syntax error should be returned for an incomplete syntax tree, but %v is returned", err)
+	}
+
+	errs := []struct {
+		rules  string
+		strict bool
+		kind   error
+		target interface{}
+	}{
+		{"name == `jack`", true, ErrUnknownOperand, new(*OperandError)},
+		{"labels[`env`] == `prod`", true, ErrUnknownOperand, new(*OperandError)},
+		{"city == `x`", false, ErrMissingMethod, new(*MethodError)},
+		{"pointer in `x`", false, ErrMissingMethod, new(*MethodError)},
+		{"age == `20`", false, ErrTypeMismatch, new(*TypeError)},
+		{"app > `1.x`", false, ErrTypeMismatch, new(*TypeError)},
+		{"labels[1] == `x`", false, ErrTypeMismatch, new(*TypeError)},
+		{"remote check `slow`", false, ErrTimeout, nil},
+	}
+	for _, table := range errs {
+		p, err := ParserInit(table.rules)
+		if err != nil {
+			t.Errorf("error happens when initializing the parser with `%s`: %v", table.rules, err)
+			continue
+		}
+		p.SetStrict(table.strict)
+		p.SetTimeout(5 * time.Millisecond)
+
+		_, err = p.Examine(&ctx)
+		if !errors.Is(err, table.kind) || table.target != nil && !errors.As(err, table.target) {
+			t.Errorf("%v should be returned for rule `%s`, but %v is returned", table.kind, table.rules, err)
+		}
+		if table.target == nil {
+			continue
+		}
+		if verr := p.Validate(reflect.TypeOf(ctx)); !errors.Is(verr, table.kind) && !table.strict {
+			t.Errorf("%v should be reported by Validate for rule `%s`, but %v is returned", table.kind, table.rules, verr)
+		}
+	}
+
+	p, _ := ParserInit("name == `jack` && age > 1")
+	p.SetStrict(true)
+	var oerr *OperandError
+	if _, err := p.Examine(&ctx); !errors.As(err, &oerr) || len(oerr.Operands) != 1 || oerr.Operands[0] != "name" {
+		t.Errorf("unknown operand name should be reported, but %v is returned", err)
+	}
+
+	p, _ = ParserInit("app > `1.x`")
+	var perr *TypeError
+	if _, err := p.Examine(&ctx); !errors.As(err, &perr) || perr.Operand != "app" || perr.Err == nil {
+		t.Errorf("type error should wrap the invalid version, but %v is returned", err)
+	}
+
+	p, _ = ParserInit("age > 1")
+	var nerr *NilValueError
+	if _, err := p.Examine(map[string]interface{}{"age": nil}); !errors.Is(err, ErrNilValue) ||
+		!errors.As(err, &nerr) || nerr.Operand != "age" || nerr.Kind != "value" {
+		t.Errorf("nil value of age should be reported, but %v is returned", err)
+	}
+
+	contexts := []interface{}{nil, 5, (*TestContext)(nil), map[int]string{}, []string{}}
+	for _, c := range contexts {
+		var cerr *ContextError
+		if _, err := p.Examine(c); !errors.Is(err, ErrUnsupportedContext) || !errors.As(err, &cerr) {
+			t.Errorf("unsupported context should be reported for %#v, but %v is returned", c, err)
+		}
+	}
+	e, err := p.Compile(reflect.TypeOf(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Examine(map[string]interface{}{}); !errors.Is(err, ErrUnsupportedContext) {
+		t.Errorf("unsupported context should be reported by the evaluator, but %v is returned", err)
+	}
+	var jerr *json.SyntaxError
+	if _, err := p.ExamineJSON([]byte(`{"app":}`)); !errors.Is(err, ErrUnsupportedContext) || !errors.As(err, &jerr) {
+		t.Errorf("unsupported context should wrap the JSON error, but %v is returned", err)
+	}
+	if _, err := p.ExamineJSON([]byte(`[1]`)); !errors.Is(err, ErrUnsupportedContext) {
+		t.Errorf("unsupported context should be reported for a JSON array, but %v is returned", err)
+	}
+}
+
+func TestEmbeddedFields(t *testing.T) {
+	type ValueContext struct {
+		ClientInfo
+		Age int `rule:"age"`
+	}
+
+	type PointerContext struct {
+		*ClientInfo
+		Platform string `rule:"platform"` // hides the promoted field
+	}
+
+	type ConflictContext struct {
+		ClientInfo
+		DeviceInfo // channel conflicts with ClientInfo
+	}
+
+	type DepthContext struct {
+		Inner
+		DeviceInfo // channel hides the one of Inner.ClientInfo at a deeper depth
+	}
+
+	type TaggedContext struct {
+		ClientInfo `rule:"client"` // not promoted since it is tagged
+	}
+
+	type UnexportedContext struct {
+		session     // the exported fields of an unexported embedded struct are promoted
+		age     int `rule:"age"`
+	}
+
+	client := ClientInfo{"ios", TypeT{14}, "appstore"}
+	device := DeviceInfo{"iphone", "preinstalled"}
+
+	tables := []struct {
+		context interface{}
+		rules   string
+		rst     bool
+		unbound string
+	}{
+		{ValueContext{client, 20}, "platform == `ios` && version >= 14 && age > 18", true, ""},
+		{&ValueContext{client, 20}, "channel != `appstore`", false, ""},
+		{PointerContext{&client, "android"}, "platform == `android` && version < 20", true, ""},
+		{ConflictContext{client, device}, "model == `iphone` && platform == `ios`; channel == `x`", true, "channel"},
+		{DepthContext{Inner{client}, device}, "channel == `preinstalled` && platform == `ios`", true, ""},
+		{TaggedContext{client}, "client.platform == `ios`; platform == `x`", true, "platform"},
+		{UnexportedContext{session{"x", 1}, 20}, "token == `x`; age > 30; level > 1", true, "age,level"},
+	}
+
+	for _, table := range tables {
+		p, err := ParserInit(table.rules)
+
+		if err != nil {
+			t.Errorf("error happens when initializing the parser with `%s`: %v", table.rules, err)
+			continue
+		}
+
+		rst, err := p.Examine(table.context)
+
+		if err != nil || rst != table.rst {
+			t.Errorf("result of rule `%s` should be %v, but (%v, %v) is returned", table.rules, table.rst, rst, err)
+		}
+
+		e, _ := p.Compile(reflect.TypeOf(table.context))
+		if unbound := strings.Join(e.Unbound(), ","); unbound != table.unbound {
+			t.Errorf("unbound operands of rule `%s` should be `%s`, but `%s` is returned", table.rules, table.unbound, unbound)
+		}
+	}
+
+	// a nil embedded pointer
+	p, _ := ParserInit("platform == `ios`; version > 1")
+	if _, err := p.Examine(PointerContext{nil, "ios"}); err == nil {
+		t.Error("error should happen when the embedded pointer is nil")
+	}
+}
+
+func TestMapContext(t *testing.T) {
+	headers := map[string]string{"platform": "ios", "lang": "en"}
+	doc := map[string]interface{}{
+		"age":    float64(20), // as decoded from JSON
+		"vip":    true,
+		"name":   "jack",
+		"tags":   []interface{}{"a", "b"},
+		"coupon": nil,
+		"device": map[string]interface{}{"os": map[string]interface{}{"version": int64(14)}},
+	}
+	versions := map[string]TypeT{"ver": {14}}
+
+	tables := []struct {
+		context interface{}
+		rules   string
+		rst     bool
+		err     bool
+	}{
+		{headers, "platform == `ios` && lang != `fr`", true, false},
+		{&headers, "platform == `android` || lang == `en`", true, false},
+		{headers, "platform == `ios` && missing == `x`", true, false},
+		{headers, "platform == 1", false, true},
+		{doc, "age >= 18 && age < 30.5 && vip == true && name > `j`", true, false},
+		{doc, "device.os.version == 14 && device.os.build == `x`", true, false},
+		{doc, "tags has `b` && name has `z`", false, false},
+		{doc, "age == `20`", false, true},
+		{doc, "tags == `a`", false, true},
+		{doc, "coupon == `x`", false, true},
+		{doc, "name in `x`", false, true},
+		{versions, "ver >= 14 && ver < 20", true, false},
+		{map[int]string{}, "a == 1", false, true},
+	}
+
+	for _, table := range tables {
+		p, err := ParserInit(table.rules)
+
+		if err != nil {
+			t.Errorf("error happens when initializing the parser with `%s`: %v", table.rules, err)
+			continue
+		}
+
+		p.RegisterOperator("has", func(val interface{}, arg string) (int, error) {
+			switch v := val.(type) {
+			case string:
+				if strings.Contains(v, arg) {
+					return 0, nil
+				}
+			case []interface{}:
+				for _, x := range v {
+					if x == arg {
+						return 0, nil
+					}
+				}
+			}
+			return 1, nil
+		})
+
+		rst, err := p.Examine(table.context)
+
+		if table.err {
+			if err == nil {
+				t.Errorf("error should happen when examining with rule `%s`", table.rules)
+			}
+		} else if err != nil || rst != table.rst {
+			t.Errorf("result of rule `%s` should be %v, but (%v, %v) is returned", table.rules, table.rst, rst, err)
+		}
+	}
+
+	// the operands missing from the map fail a strict parser
+	p, _ := ParserInit("platform == `ios`; device == `x`")
+	p.SetStrict(true)
+	if _, err := p.Examine(headers); err == nil || !strings.Contains(err.Error(), "device") {
+		t.Errorf("error naming the missing operand should happen, but %v is returned", err)
+	}
+
+	x, err := p.Explain(map[string]interface{}{"platform": "ios"})
+	if err != nil || !x.Rules[0].Bound || x.Rules[0].Actual != "ios" || x.Rules[1].Bound {
+		t.Errorf("explanation of the map context is not correct: %v, %v", x, err)
+	}
+}
+
 func BenchmarkSum(b *testing.B) {
 
 	type TestContext struct {
@@ -410,3 +2401,215 @@ func BenchmarkSum(b *testing.B) {
 		p.Examine(context)
 	}
 }
+
+func BenchmarkCustomMethod(b *testing.B) {
+
+	p, _ := ParserInit("t < 30 && t > 10")
+	context := TestContext1{TypeT{20}}
+
+	for i := 0; i < b.N; i++ {
+		p.Examine(context)
+	}
+}
+
+func BenchmarkStrategies(b *testing.B) {
+
+	type TestContext struct {
+		Category string `rule:"category"`
+		Age      int    `rule:"age"`
+		Score    float64
+	}
+
+	p, _ := ParserInit("category == `regular` && age > 18 || category == `kids`")
+	context := TestContext{"regular", 20, 1.5}
+
+	for _, strategy := range []Strategy{Auto, Sequential, WorkerPool, FanOut} {
+		p.SetStrategy(strategy)
+		b.Run(strategy.String(), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				p.Examine(context)
+			}
+		})
+	}
+}
+
+func TestExamineJSON(t *testing.T) {
+	doc := []byte(`{"id":9007199254740993,"uploader":"user_1","score":4.5,"live":true,` +
+		`"tags":["sports","live"],"owner":{"name":"jack","level":3},"cover":null}`)
+
+	tables := []struct {
+		data  []byte
+		rules string
+		rst   bool
+		err   bool
+	}{
+		{doc, "uploader != `user_2` && live == true && score > 4", true, false},
+		{doc, "id == 9007199254740993 && id > 9007199254740992", true, false},
+		{doc, "score >= 4.5 && score < 5 && owner.level == 3 && owner.level < 3.5", true, false},
+		{doc, "owner.name == `jack` && owner.age > 18 && missing == 1", true, false},
+		{doc, "tags has `live` && !(tags has `pets`)", true, false},
+		{doc, "score == `4.5`", false, true},
+		{doc, "cover == `x`", false, true},
+		{doc, "owner == 1", false, true},
+		// numbers are not strings even though json.Number is of string kind
+		{doc, "score startsWith `4`", false, true},
+		{doc, "id contains `9`", false, true},
+		{doc, "score =~ `^4`", false, true},
+		{doc, "uploader startsWith `user` && uploader =~ `_1$`", true, false},
+		{[]byte(`[1, 2]`), "a == 1", false, true},
+		{[]byte(`null`), "a == 1", false, true},
+		{[]byte(`{"a": 1} {}`), "a == 1", false, true},
+		{[]byte(`{"a": `), "a == 1", false, true},
+	}
+
+	for _, table := range tables {
+		p, err := ParserInit(table.rules)
+
+		if err != nil {
+			t.Errorf("error happens when initializing the parser with `%s`: %v", table.rules, err)
+			continue
+		}
+
+		p.RegisterOperator("has", func(val interface{}, arg string) (int, error) {
+			for _, x := range val.([]interface{}) {
+				if x == arg {
+					return 0, nil
+				}
+			}
+			return 1, nil
+		})
+
+		rst, err := p.ExamineJSON(table.data)
+
+		if table.err {
+			if err == nil {
+				t.Errorf("error should happen when examining `%s` with rule `%s`", table.data, table.rules)
+			}
+		} else if err != nil || rst != table.rst {
+			t.Errorf("result of rule `%s` should be %v, but (%v, %v) is returned", table.rules, table.rst, rst, err)
+		}
+	}
+
+	// a json.Number field of a struct is a number as well
+	type TestContext struct {
+		Price json.Number `rule:"price"`
+	}
+	for _, rules := range []string{"price startsWith `1`", "price =~ `^1`", "price eqi `12`"} {
+		p, _ := ParserInit(rules)
+		if _, err := p.Examine(TestContext{"12"}); !errors.Is(err, ErrTypeMismatch) {
+			t.Errorf("type mismatch should be reported for rule `%s`, but %v is returned", rules, err)
+		}
+	}
+}
+
+type Platform string
+
+type Location struct {
+	Lat, Lng float64
+}
+
+func TestRegisterOperator(t *testing.T) {
+	type Context struct {
+		Name     string    `rule:"name"`
+		Platform Platform  `rule:"platform"`
+		Score    *int      `rule:"score"`
+		Ver      TypeT     `rule:"ver"`
+		Loc      Location  `rule:"loc"`
+		Tags     []string  `rule:"tags"`
+		Nothing  *Location `rule:"nothing"`
+	}
+
+	prefix := func(val string, arg string) (int, error) {
+		if strings.HasPrefix(val, arg) {
+			return 0, nil
+		}
+		return 1, nil
+	}
+	if err := RegisterOperator("test_prefix", prefix); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterOperator("test_near", func(ctx context.Context, loc Location, arg string) (int, error) {
+		if ctx == nil {
+			return 0, errors.New("context is expected")
+		}
+		if loc.Lat > 30 {
+			return 0, nil
+		}
+		return 1, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterOperator("test_any", Operator(func(val interface{}, arg string) (int, error) {
+		if fmt.Sprint(val) == arg {
+			return 0, nil
+		}
+		return 1, nil
+	})); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, table := range []struct {
+		name string
+		fn   interface{}
+	}{
+		{"==", prefix},
+		{"bad name", prefix},
+		{"test", nil},
+		{"test", "prefix"},
+		{"test", func(string) (int, error) { return 0, nil }},
+		{"test", func(string, string) (bool, error) { return false, nil }},
+		{"test", func(context.Context, string, int) (int, error) { return 0, nil }},
+	} {
+		if err := RegisterOperator(table.name, table.fn); err == nil {
+			t.Errorf("error should happen when registering %v as operator %s", table.fn, table.name)
+		}
+	}
+
+	score := 60
+	ctx := Context{"jack", "android", &score, TypeT{14}, Location{35, 120}, []string{"a"}, nil}
+
+	tables := []struct {
+		rules string
+		rst   bool
+		err   bool
+	}{
+		{"name test_prefix `ja` && platform test_prefix `and` && loc test_near `x`", true, false},
+		{"name test_prefix `x` || loc test_near `x`", true, false},
+		{"score test_any `60` && tags test_any `[a]` && name test_any `jack`", true, false},
+		{"score local `6` && platform local `andro`", true, false},
+		{"ver test_prefix `x`", false, true},
+		{"tags test_prefix `a`", false, true},
+		{"nothing test_near `x`", false, true},
+		{"score unknown `6`", false, true},
+	}
+
+	for _, table := range tables {
+		p, err := ParserInit(table.rules)
+
+		if err != nil {
+			t.Errorf("error happens when initializing the parser with `%s`: %v", table.rules, err)
+			continue
+		}
+
+		// the operators of the parser go before the global ones
+		p.RegisterOperator("test_prefix", func(val string, arg string) (int, error) {
+			return 0, nil
+		})
+		p.RegisterOperator("local", func(val int, arg string) (int, error) {
+			return strings.Compare(strconv.Itoa(val), arg+"0"), nil
+		})
+		p.RegisterOperator("local", func(val string, arg string) (int, error) {
+			return strings.Compare(val, "android"), nil
+		})
+
+		rst, err := p.Examine(&ctx)
+
+		if table.err {
+			if err == nil {
+				t.Errorf("error should happen when examining with rule `%s`", table.rules)
+			}
+		} else if err != nil || rst != table.rst {
+			t.Errorf("result of rule `%s` should be %v, but (%v, %v) is returned", table.rules, table.rst, rst, err)
+		}
+	}
+}
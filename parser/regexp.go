@@ -0,0 +1,113 @@
+package parser
+
+import (
+	"context"
+	"fmt"
+	"github.com/kuangwanjing/ruleparser/ast"
+	"reflect"
+	"regexp"
+)
+
+// operationName returns the name of the operation, which is "matches" for "=~".
+func operationName(op string) string {
+	if op == "=~" {
+		return "matches"
+	}
+	return op
+}
+
+// isMatchOperation tells whether the operation matches the field with a regular expression.
+func isMatchOperation(op string) bool {
+	return operationName(op) == "matches"
+}
+
+// compilePatterns compiles the regular expressions of the rules, so that an invalid one is
+// reported when the rules are parsed and the compiled ones are shared by all the examinations.
+func compilePatterns(rules []*ast.CmpExpr) (map[*ast.CmpExpr]*regexp.Regexp, error) {
+	patterns := make(map[*ast.CmpExpr]*regexp.Regexp)
+	for _, rule := range rules {
+		if !isMatchOperation(rule.Op) {
+			continue
+		}
+		if rule.Y.Kind != ast.String {
+			return nil, syntaxError(rule.Y.ValuePos, "regular expression of %s should be a string at %d",
+				rule.Operand(), rule.Y.ValuePos)
+		}
+		re, err := regexp.Compile(rule.Y.Value)
+		if err != nil {
+			return nil, causedSyntaxError(rule.Y.ValuePos, err, "invalid regular expression `%s` at %d: %v",
+				rule.Y.Value, rule.Y.ValuePos, err)
+		}
+		patterns[rule] = re
+	}
+	return patterns, nil
+}
+
+var stringerType = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+
+// compileMatch returns the function matching a field of type t with the regular expression of
+// the rule, or nil when the rule is not a match or the field is neither a string nor a
+// fmt.Stringer. A field of string kind is matched by its value even if it is a fmt.Stringer,
+// except a json.Number, which is a number.
+func (p *RuleParser) compileMatch(rule *ast.CmpExpr, t reflect.Type) func(context.Context, reflect.Value) (bool, error) {
+	if !isMatchOperation(rule.Op) {
+		return nil
+	}
+	re := p.patterns[rule]
+
+	derefs, stringer := 0, false
+	for et := t; ; et = et.Elem() {
+		if et == numberType {
+			return nil
+		}
+		if et.Kind() == reflect.String {
+			break
+		}
+		if et.Implements(stringerType) {
+			stringer = true
+			break
+		}
+		if et.Kind() != reflect.Ptr {
+			return nil
+		}
+		derefs += 1
+	}
+
+	return func(_ context.Context, v reflect.Value) (bool, error) {
+		for i := 0; i < derefs; i++ {
+			if v.IsNil() {
+				return false, nilError("pointer", rule)
+			}
+			v = v.Elem()
+		}
+		if !stringer {
+			return re.MatchString(v.String()), nil
+		}
+		if v.Kind() == reflect.Ptr && v.IsNil() {
+			return false, nilError("pointer", rule)
+		}
+		return re.MatchString(v.Interface().(fmt.Stringer).String()), nil
+	}
+}
+
+// compileDynamicMatch returns the function matching a value, whose type is only known when the
+// context is examined, with the regular expression of the rule.
+func (p *RuleParser) compileDynamicMatch(rule *ast.CmpExpr) func(context.Context, reflect.Value) (bool, error) {
+	re := p.patterns[rule]
+	return func(_ context.Context, v reflect.Value) (bool, error) {
+		v, ok := dynamicValue(v)
+		if !ok {
+			return false, nilError("value", rule)
+		}
+		if v.Type() == numberType {
+			return false, typeError(rule, rule.Op+" is not available for "+v.Type().String()+" value "+rule.Operand())
+		}
+		if v.Kind() == reflect.String {
+			return re.MatchString(v.String()), nil
+		}
+		if s, ok := v.Interface().(fmt.Stringer); ok {
+			return re.MatchString(s.String()), nil
+		}
+		return false, typeError(rule, rule.Op+" is not available for "+v.Type().String()+" value "+rule.Operand())
+	}
+}
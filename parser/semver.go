@@ -0,0 +1,171 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/kuangwanjing/ruleparser/ast"
+	"reflect"
+	"strings"
+)
+
+// semver is a semantic version as specified by https://semver.org, whose numeric identifiers
+// are kept as written so that they are compared regardless of their size.
+type semver struct {
+	core  [3]string // major, minor and patch versions
+	pre   []string  // identifiers of the pre-release version
+	build string    // build metadata, which doesn't take part in the precedence
+}
+
+// parseSemver parses the semantic version. A leading "v" is accepted as in "v1.2.3".
+func parseSemver(s string) (*semver, error) {
+	invalid := errors.New("invalid semantic version " + s)
+	v := &semver{}
+	rest := strings.TrimPrefix(s, "v")
+
+	if i := strings.IndexByte(rest, '+'); i >= 0 {
+		rest, v.build = rest[:i], rest[i+1:]
+		for _, id := range strings.Split(v.build, ".") {
+			if !isSemverIdentifier(id) {
+				return nil, invalid
+			}
+		}
+	}
+
+	if i := strings.IndexByte(rest, '-'); i >= 0 {
+		rest, v.pre = rest[:i], strings.Split(rest[i+1:], ".")
+		for _, id := range v.pre {
+			if !isSemverIdentifier(id) || isNumeric(id) && len(id) > 1 && id[0] == '0' {
+				return nil, invalid
+			}
+		}
+	}
+
+	core := strings.Split(rest, ".")
+	if len(core) != 3 {
+		return nil, invalid
+	}
+	for i, n := range core {
+		if !isNumeric(n) || len(n) > 1 && n[0] == '0' {
+			return nil, invalid
+		}
+		v.core[i] = n
+	}
+
+	return v, nil
+}
+
+func isSemverIdentifier(id string) bool {
+	if id == "" {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+func isNumeric(id string) bool {
+	if id == "" {
+		return false
+	}
+	for _, c := range id {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// cmpNumeric compares two numeric identifiers without leading zeros.
+func cmpNumeric(a, b string) int {
+	if len(a) != len(b) {
+		return cmpInt64(int64(len(a)), int64(len(b)))
+	}
+	return strings.Compare(a, b)
+}
+
+// cmp compares the precedence of the versions.
+func (v *semver) cmp(w *semver) int {
+	for i := range v.core {
+		if ret := cmpNumeric(v.core[i], w.core[i]); ret != 0 {
+			return ret
+		}
+	}
+
+	// a pre-release version has a lower precedence than the normal version
+	if len(v.pre) == 0 || len(w.pre) == 0 {
+		return cmpInt64(int64(len(w.pre)), int64(len(v.pre)))
+	}
+
+	for i := 0; i < len(v.pre) && i < len(w.pre); i++ {
+		a, b := v.pre[i], w.pre[i]
+		var ret int
+		switch an, bn := isNumeric(a), isNumeric(b); {
+		case an && bn:
+			ret = cmpNumeric(a, b)
+		case an:
+			// numeric identifiers have a lower precedence than the alphanumeric ones
+			ret = -1
+		case bn:
+			ret = 1
+		default:
+			ret = strings.Compare(a, b)
+		}
+		if ret != 0 {
+			return ret
+		}
+	}
+	return cmpInt64(int64(len(v.pre)), int64(len(w.pre)))
+}
+
+// cmpSemver compares the semantic versions by their precedence.
+func cmpSemver(a, b string) (int, error) {
+	v, err := parseSemver(a)
+	if err != nil {
+		return 0, err
+	}
+	w, err := parseSemver(b)
+	if err != nil {
+		return 0, err
+	}
+	return v.cmp(w), nil
+}
+
+// compileSemver returns the function comparing a string field holding a semantic version with
+// the version of the rule by their precedence. The field is either tagged with the option semver
+// or compared with a version literal, which is verified when the rules are parsed.
+func compileSemver(rule *ast.CmpExpr, t reflect.Type) (func(context.Context, reflect.Value) (bool, error), error) {
+	if elemType(t).Kind() != reflect.String {
+		return nil, typeError(rule, "semver is not available for "+elemType(t).Kind().String()+" field "+rule.Operand())
+	}
+	if !isBasicOperation(rule.Op) {
+		return nil, typeError(rule, rule.Op+" is not available for "+rule.Operand())
+	}
+	if rule.Y.Kind != ast.String && rule.Y.Kind != ast.Version {
+		return nil, typeError(rule, rule.Y.Kind.String()+" literal "+rule.Y.Value+
+			" does not match semantic version field "+rule.Operand())
+	}
+
+	version, err := parseSemver(rule.Y.Value)
+	if err != nil {
+		return nil, &TypeError{rule.Operand(), fmt.Sprintf("%v of %s at %d", err, rule.Operand(), rule.Y.ValuePos), err}
+	}
+
+	op := GetBasicOperation(rule.Op)
+	return func(_ context.Context, v reflect.Value) (bool, error) {
+		for v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return false, nilError("pointer", rule)
+			}
+			v = v.Elem()
+		}
+		actual, err := parseSemver(v.String())
+		if err != nil {
+			return false, &TypeError{rule.Operand(), err.Error() + " of " + rule.Operand(), err}
+		}
+		return op(actual.cmp(version)), nil
+	}, nil
+}
@@ -0,0 +1,127 @@
+package parser
+
+import (
+	"context"
+	"github.com/kuangwanjing/ruleparser/ast"
+	"math"
+	"reflect"
+)
+
+// isSetOperation tells whether the operation looks up the value of the field in a list.
+func isSetOperation(op string) bool {
+	return op == "in" || op == "not_in"
+}
+
+// valueSet is the set of the values of a list literal, so that `in` and `not_in` don't go
+// through the list on every examination.
+type valueSet struct {
+	strings map[string]struct{}
+	ints    map[int64]struct{}
+	uints   map[uint64]struct{} // integers beyond the range of int64
+	floats  map[float64]struct{}
+	bools   map[bool]struct{}
+	kinds   map[ast.LitKind]bool // kinds of the elements
+	binary  bool                 // whether every integer is either 0 or 1
+}
+
+func newValueSet(lit *ast.BasicLit) *valueSet {
+	s := &valueSet{
+		strings: map[string]struct{}{},
+		ints:    map[int64]struct{}{},
+		uints:   map[uint64]struct{}{},
+		floats:  map[float64]struct{}{},
+		bools:   map[bool]struct{}{},
+		kinds:   map[ast.LitKind]bool{},
+		binary:  true,
+	}
+
+	for _, elt := range lit.Elts {
+		s.kinds[elt.Kind] = true
+		switch x := elt.Val.(type) {
+		case string:
+			s.strings[x] = struct{}{}
+		case int64:
+			s.ints[x] = struct{}{}
+			s.floats[float64(x)] = struct{}{}
+			if x == 0 || x == 1 {
+				s.bools[x == 1] = struct{}{}
+			} else {
+				s.binary = false
+			}
+		case uint64:
+			s.uints[x] = struct{}{}
+			s.floats[float64(x)] = struct{}{}
+			s.binary = false
+		case float64:
+			s.floats[x] = struct{}{}
+		case bool:
+			s.bools[x] = struct{}{}
+		}
+	}
+
+	return s
+}
+
+// matches tells whether every element of the list can be compared with a value of the kind, in
+// the same way as literalMatches does for a single literal.
+func (s *valueSet) matches(k reflect.Kind) bool {
+	switch k {
+	case reflect.String:
+		return len(s.kinds) == 1 && s.kinds[ast.String]
+	case reflect.Bool:
+		return !s.kinds[ast.String] && !s.kinds[ast.Float] && (!s.kinds[ast.Int] || s.binary)
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return len(s.kinds) == 1 && s.kinds[ast.Int]
+	case reflect.Float32, reflect.Float64:
+		return !s.kinds[ast.String] && !s.kinds[ast.Bool]
+	}
+	return false
+}
+
+// contains tells whether the value of basic data type is in the set.
+func (s *valueSet) contains(v reflect.Value) bool {
+	var ok bool
+	switch v.Kind() {
+	case reflect.String:
+		_, ok = s.strings[v.String()]
+	case reflect.Bool:
+		_, ok = s.bools[v.Bool()]
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int, reflect.Int64:
+		_, ok = s.ints[v.Int()]
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if u := v.Uint(); u <= math.MaxInt64 {
+			_, ok = s.ints[int64(u)]
+		} else {
+			_, ok = s.uints[u]
+		}
+	case reflect.Float32, reflect.Float64:
+		_, ok = s.floats[v.Float()]
+	}
+	return ok
+}
+
+// compileSet returns the function looking up a field of basic data type in the list of the rule.
+func compileSet(rule *ast.CmpExpr, et reflect.Type) (func(context.Context, reflect.Value) (bool, error), error) {
+	if rule.Y.Kind != ast.List {
+		return nil, typeError(rule, rule.Op+" of "+rule.Operand()+" expects a list of values, but "+
+			rule.Y.Kind.String()+" literal "+rule.Y.Value+" is found")
+	}
+
+	set := newValueSet(rule.Y)
+	if !set.matches(et.Kind()) {
+		return nil, typeError(rule, "list "+ast.Format(rule.Y)+" does not match "+et.Kind().String()+
+			" field "+rule.Operand())
+	}
+
+	in := rule.Op == "in"
+	return func(_ context.Context, v reflect.Value) (bool, error) {
+		for v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return false, nilError("pointer", rule)
+			}
+			v = v.Elem()
+		}
+		return set.contains(v) == in, nil
+	}, nil
+}
@@ -0,0 +1,131 @@
+package parser
+
+import (
+	"context"
+	"go/token"
+	"reflect"
+	"runtime"
+)
+
+// Strategy tells how the rules are scheduled when a context is examined.
+type Strategy int
+
+const (
+	// Auto compares the fields of basic data type inline and examines the rules calling a
+	// method of the field concurrently, each in its own goroutine. It is the default strategy.
+	Auto Strategy = iota
+	// Sequential examines the rules one after another in the goroutine of the caller and skips
+	// the rules which can not change the result any more, like `&&` and `||` do in Go.
+	Sequential
+	// WorkerPool compares the fields of basic data type inline like Auto does and examines the
+	// rules calling a method concurrently with a bounded number of goroutines, see SetWorkers.
+	WorkerPool
+	// FanOut examines every rule concurrently, each in its own goroutine.
+	FanOut
+)
+
+var strategies = [...]string{
+	Auto:       "auto",
+	Sequential: "sequential",
+	WorkerPool: "worker pool",
+	FanOut:     "fan-out",
+}
+
+func (s Strategy) String() string {
+	if s < 0 || int(s) >= len(strategies) {
+		return "unknown"
+	}
+	return strategies[s]
+}
+
+// SetStrategy sets how the rules are scheduled. The timeout and the context of the examination
+// apply to every strategy, but a rule examined in the goroutine of the caller can only be
+// interrupted by its own method honoring the context.
+func (p *RuleParser) SetStrategy(s Strategy) {
+	p.strategy = s
+}
+
+// SetWorkers sets the number of goroutines of the WorkerPool strategy. The number of CPUs is
+// used when n is not positive.
+func (p *RuleParser) SetWorkers(n int) {
+	p.workers = n
+}
+
+// inline tells whether the rule is examined in the goroutine of the caller.
+func (e *Evaluator) inline(rule *compiledRule) bool {
+	switch e.p.strategy {
+	case Auto, WorkerPool:
+		return rule.builtin
+	case Sequential:
+		return true
+	}
+	return false
+}
+
+// dispatch runs the rules of the indices concurrently according to the strategy of the parser.
+// The workers of a pool skip the rules left once ctx is done.
+func (e *Evaluator) dispatch(ctx context.Context, idxs []int, run func(i int)) {
+	if e.p.strategy != WorkerPool {
+		for _, i := range idxs {
+			go run(i)
+		}
+		return
+	}
+
+	workers := e.p.workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if workers > len(idxs) {
+		workers = len(idxs)
+	}
+
+	jobs := make(chan int, len(idxs))
+	for _, i := range idxs {
+		jobs <- i
+	}
+	close(jobs)
+
+	for w := 0; w < workers; w++ {
+		go func() {
+			for i := range jobs {
+				if ctx.Err() != nil {
+					continue
+				}
+				run(i)
+			}
+		}()
+	}
+}
+
+// sequential examines the rules of the expression in the goroutine of the caller and records
+// their outcomes, examining a rule only when its result is needed. neg tells whether n is
+// negated, see exprNode.resolve. Once ctx is done, the rules calling a method fail with the
+// error of ctx without being examined, while the rules compared by the parser, which never
+// block, are still examined and may decide the result like they do with the other strategies.
+func (e *Evaluator) sequential(ctx, parent context.Context, n *exprNode, val reflect.Value, o *outcomes, neg bool) {
+	switch n.op {
+	case token.NOT:
+		e.sequential(ctx, parent, n.x, val, o, !neg)
+		return
+	case token.LAND, token.LOR:
+		e.sequential(ctx, parent, n.x, val, o, neg)
+		if v, ok, _ := n.x.resolve(o, neg); ok && v == (n.op == token.LOR) {
+			return
+		}
+		e.sequential(ctx, parent, n.y, val, o, neg)
+		return
+	}
+
+	// skipped rules are recorded before the examination starts.
+	if o.done[n.rule] {
+		return
+	}
+	rule := e.rules[n.rule]
+	if !rule.builtin && ctx.Err() != nil {
+		o.record(n.rule, false, doneError(parent))
+		return
+	}
+	v, err := rule.eval(ctx, val)
+	o.record(n.rule, v, ruleError(ctx, parent, err))
+}
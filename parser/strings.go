@@ -0,0 +1,72 @@
+package parser
+
+import (
+	"context"
+	"github.com/kuangwanjing/ruleparser/ast"
+	"reflect"
+	"strings"
+)
+
+// stringOperations are the built-in operations of the string fields. eqi compares the strings
+// under Unicode simple case folding like strings.EqualFold, so "σ", "ς" and "Σ" are equal while
+// "ß" doesn't equal "SS".
+var stringOperations = map[string]func(s, arg string) bool{
+	"contains":   strings.Contains,
+	"startsWith": strings.HasPrefix,
+	"endsWith":   strings.HasSuffix,
+	"eqi":        strings.EqualFold,
+}
+
+// compileString returns the function examining a field of type t with the string operation of
+// the rule, or nil when the operation is not one of them or the field is not of string kind. A
+// json.Number is a number even though it is of string kind, so it is rejected.
+func compileString(rule *ast.CmpExpr, t reflect.Type) (func(context.Context, reflect.Value) (bool, error), error) {
+	op, ok := stringOperations[rule.Op]
+	if !ok || elemType(t).Kind() != reflect.String {
+		return nil, nil
+	}
+	if elemType(t) == numberType {
+		return nil, typeError(rule, rule.Op+" is not available for number field "+rule.Operand())
+	}
+	if rule.Y.Kind != ast.String {
+		return nil, typeError(rule, rule.Y.Kind.String()+" literal "+rule.Y.Value+" does not match string field "+
+			rule.Operand())
+	}
+
+	arg := rule.Y.Val.(string)
+	return func(_ context.Context, v reflect.Value) (bool, error) {
+		for v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return false, nilError("pointer", rule)
+			}
+			v = v.Elem()
+		}
+		return op(v.String(), arg), nil
+	}, nil
+}
+
+// compileDynamicString returns the function examining a value, whose type is only known when
+// the context is examined, with the string operation of the rule, or nil when the operation is
+// not one of them.
+func compileDynamicString(rule *ast.CmpExpr) (func(context.Context, reflect.Value) (bool, error), error) {
+	op, ok := stringOperations[rule.Op]
+	if !ok {
+		return nil, nil
+	}
+	if rule.Y.Kind != ast.String {
+		return nil, typeError(rule, rule.Y.Kind.String()+" literal "+rule.Y.Value+" does not match string value "+
+			rule.Operand())
+	}
+
+	arg := rule.Y.Val.(string)
+	return func(_ context.Context, v reflect.Value) (bool, error) {
+		v, ok := dynamicValue(v)
+		if !ok {
+			return false, nilError("value", rule)
+		}
+		if v.Kind() != reflect.String || v.Type() == numberType {
+			return false, typeError(rule, rule.Op+" is not available for "+v.Type().String()+" value "+rule.Operand())
+		}
+		return op(v.String(), arg), nil
+	}, nil
+}
@@ -0,0 +1,200 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/kuangwanjing/ruleparser/ast"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// timeLayouts are the layouts of the time literals, tried in order. A time without a zone is in
+// UTC.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// SetClock sets the clock giving the current time to the relative time literals such as
+// `now-7d`. time.Now is used by default.
+func (p *RuleParser) SetClock(now func() time.Time) {
+	p.clock = now
+}
+
+func (p *RuleParser) now() time.Time {
+	if p.clock != nil {
+		return p.clock()
+	}
+	return time.Now()
+}
+
+// parseTime parses the time literal, which is either a time in one of timeLayouts or a time
+// relative to the current time such as "now", "now-7d" or "now+1h30m". The returned function
+// gives the time when it is called.
+func parseTime(s string, now func() time.Time) (func() time.Time, error) {
+	if rest := strings.TrimPrefix(s, "now"); rest != s {
+		var d time.Duration
+		if rest != "" {
+			if rest[0] != '+' && rest[0] != '-' {
+				return nil, errors.New("invalid time " + s)
+			}
+			var err error
+			if d, err = parseDuration(rest); err != nil {
+				return nil, errors.New("invalid time " + s)
+			}
+		}
+		return func() time.Time {
+			return now().Add(d)
+		}, nil
+	}
+
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return func() time.Time {
+				return t
+			}, nil
+		}
+	}
+	return nil, errors.New("invalid time " + s)
+}
+
+// parseDuration parses the duration like time.ParseDuration does, and also accepts the units
+// "d" for a day of 24 hours and "w" for a week of 7 days, such as "7d" or "1w2d12h".
+func parseDuration(s string) (time.Duration, error) {
+	if !strings.ContainsAny(s, "dw") {
+		return time.ParseDuration(s)
+	}
+
+	invalid := errors.New("invalid duration " + s)
+	rest := s
+	sign := time.Duration(1)
+	if rest != "" && (rest[0] == '+' || rest[0] == '-') {
+		if rest[0] == '-' {
+			sign = -1
+		}
+		rest = rest[1:]
+	}
+	if rest == "" {
+		return 0, invalid
+	}
+
+	var d time.Duration
+	for rest != "" {
+		i := 0
+		for i < len(rest) && (rest[i] >= '0' && rest[i] <= '9' || rest[i] == '.') {
+			i++
+		}
+		j := i
+		for j < len(rest) && !(rest[j] >= '0' && rest[j] <= '9' || rest[j] == '.') {
+			j++
+		}
+		num, unit := rest[:i], rest[i:j]
+		rest = rest[j:]
+
+		var part time.Duration
+		var err error
+		switch unit {
+		case "d", "w":
+			var f float64
+			if f, err = strconv.ParseFloat(num, 64); err == nil && num != "" {
+				part = time.Duration(f * float64(24*time.Hour))
+				if unit == "w" {
+					part *= 7
+				}
+			}
+		default:
+			part, err = time.ParseDuration(num + unit)
+		}
+		if err != nil || num == "" {
+			return 0, invalid
+		}
+		d += part
+	}
+
+	return sign * d, nil
+}
+
+// durationLiteral returns the duration of the literal, which is either a duration such as `5s`
+// or an integer of nanoseconds.
+func durationLiteral(lit *ast.BasicLit) (time.Duration, error) {
+	switch x := lit.Val.(type) {
+	case int64:
+		return time.Duration(x), nil
+	case string:
+		return parseDuration(x)
+	}
+	return 0, errors.New(lit.Kind.String() + " literal " + lit.Value + " is not a duration")
+}
+
+// timeCmp returns the comparison between a time.Time or time.Duration value and the literal,
+// parsed once for both types since the type of a dynamic value is only known when it is
+// examined. It returns nil when the literal matches neither of the types.
+func (p *RuleParser) timeCmp(lit *ast.BasicLit) func(v reflect.Value) (int, error) {
+	var at func() time.Time
+	var terr error
+	if lit.Kind == ast.String {
+		at, terr = parseTime(lit.Val.(string), p.now)
+	} else {
+		terr = errors.New(lit.Kind.String() + " literal " + lit.Value + " is not a time")
+	}
+	d, derr := durationLiteral(lit)
+
+	return func(v reflect.Value) (int, error) {
+		switch v.Type() {
+		case timeType:
+			if terr != nil {
+				return 0, terr
+			}
+			return v.Interface().(time.Time).Compare(at()), nil
+		case durationType:
+			if derr != nil {
+				return 0, derr
+			}
+			return cmpInt64(v.Int(), int64(d)), nil
+		}
+		return 0, errors.New(v.Type().String() + " is neither a time nor a duration")
+	}
+}
+
+// compileTime returns the function comparing a time.Time or time.Duration field, or a pointer
+// to it, with the literal. An invalid literal is reported when the rule is bound to the field.
+func (p *RuleParser) compileTime(rule *ast.CmpExpr, t reflect.Type) (func(context.Context, reflect.Value) (bool, error), error) {
+	et := elemType(t)
+	if et == timeType {
+		if rule.Y.Kind != ast.String {
+			return nil, typeError(rule, rule.Y.Kind.String()+" literal "+rule.Y.Value+" does not match time field "+
+				rule.Operand())
+		}
+		if _, err := parseTime(rule.Y.Value, p.now); err != nil {
+			return nil, &TypeError{rule.Operand(), fmt.Sprintf("%v of %s at %d", err, rule.Operand(), rule.Y.ValuePos), err}
+		}
+	} else if _, err := durationLiteral(rule.Y); err != nil {
+		return nil, &TypeError{rule.Operand(), fmt.Sprintf("%v of %s at %d", err, rule.Operand(), rule.Y.ValuePos), err}
+	}
+
+	cmp := p.timeCmp(rule.Y)
+	op := GetBasicOperation(rule.Op)
+	return func(_ context.Context, v reflect.Value) (bool, error) {
+		for v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return false, nilError("pointer", rule)
+			}
+			v = v.Elem()
+		}
+		ret, err := cmp(v)
+		if err != nil {
+			return false, &TypeError{rule.Operand(), err.Error(), err}
+		}
+		return op(ret), nil
+	}, nil
+}
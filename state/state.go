@@ -3,17 +3,15 @@ package state
 import (
 	"errors"
 	"fmt"
+	"github.com/kuangwanjing/ruleparser/ast"
 	"go/token"
+	"strings"
 )
 
-type RuleExpr struct {
-	Operand   string
-	Operation string
-	Value     string
-}
-
+// State is a state of the machine recognizing a single rule. Run fills the parts of the rule
+// found in the token into exp and returns the next state.
 type State interface {
-	Run(pos token.Pos, tok token.Token, lit string, exp *RuleExpr) (State, error)
+	Run(pos token.Pos, tok token.Token, lit string, exp *ast.CmpExpr) (State, error)
 }
 
 type StateOperand struct {
@@ -24,44 +22,328 @@ type StateOperation struct {
 	State
 }
 
+type StateSelector struct {
+	State
+}
+
+// StateArgument expects the operand a function is applied to.
+type StateArgument struct {
+	State
+}
+
+// StateArgumentEnd expects either a selector of the dotted operand or the end of a function.
+type StateArgumentEnd struct {
+	State
+}
+
+// StateArgumentSelector expects a selector of the operand a function is applied to.
+type StateArgumentSelector struct {
+	State
+}
+
+// StateIndex expects the key of an index expression such as labels[`env`]. Call tells whether
+// the operand belongs to a rule written as a call.
+type StateIndex struct {
+	State
+	Call bool
+}
+
+// StateIndexEnd expects the end of an index expression.
+type StateIndexEnd struct {
+	State
+	Call bool
+}
+
+// StateCallOperand expects the operand of a rule written as a call such as has_key(labels, `team`).
+type StateCallOperand struct {
+	State
+}
+
+// StateCallOperandEnd expects a selector or an index of the operand of a call, or the comma
+// followed by the value.
+type StateCallOperandEnd struct {
+	State
+}
+
+// StateCallSelector expects a selector of the operand of a call.
+type StateCallSelector struct {
+	State
+}
+
+// StateCallValue expects the value of a call.
+type StateCallValue struct {
+	State
+}
+
+// StateCallEnd expects the end of a call.
+type StateCallEnd struct {
+	State
+}
+
 type StateValue struct {
 	State
 }
 
+// StateElement expects an element of a list, which is closed by Close.
+type StateElement struct {
+	State
+	Close token.Token
+}
+
+// StateElementEnd expects either a comma followed by another element or the end of a list.
+type StateElementEnd struct {
+	State
+	Close token.Token
+}
+
 type StateEnd struct {
 	State
 }
 
-func (s StateOperand) Run(pos token.Pos, tok token.Token, lit string, exp *RuleExpr) (
+func (s StateOperand) Run(pos token.Pos, tok token.Token, lit string, exp *ast.CmpExpr) (
 	State, error) {
+	// a rule can be preceded by any number of negations and opening parentheses,
+	// which are recorded by the parser and leave the state machine waiting for the operand.
+	if tok == token.NOT || tok == token.LPAREN {
+		return StateOperand{}, nil
+	}
+
 	// examine whether the current token is an identifier
 	// if not, return an error description
 	if tok != token.IDENT {
 		return nil, errors.New(fmt.Sprintf("identifier is expected at %d", pos))
 	}
-	exp.Operand = lit
+	exp.X = &ast.Ident{NamePos: pos, Name: lit}
 	return StateOperation{}, nil
 }
 
-func (s StateOperation) Run(pos token.Pos, tok token.Token, lit string, exp *RuleExpr) (
+func (s StateOperation) Run(pos token.Pos, tok token.Token, lit string, exp *ast.CmpExpr) (
 	State, error) {
+	_, call := exp.X.(*ast.CallExpr)
+	_, index := exp.X.(*ast.IndexExpr)
+
 	switch tok {
+	case token.PERIOD:
+		// the operand is a dotted path to a nested field
+		if call || index {
+			return nil, errors.New(fmt.Sprintf("operation is expected at %d, but %s is found", pos, tok.String()))
+		}
+		return StateSelector{}, nil
+	case token.LBRACK:
+		// an entry of the map operand is looked up by its key
+		if call {
+			return nil, errors.New(fmt.Sprintf("operation is expected at %d, but %s is found", pos, tok.String()))
+		}
+		exp.X = &ast.IndexExpr{X: exp.X, Lbrack: pos}
+		return StateIndex{}, nil
+	case token.LPAREN:
+		id, ok := exp.X.(*ast.Ident)
+		// a function such as any(tags) is applied to the operand
+		if ok && ast.IsFunc(id.Name) {
+			exp.X = &ast.CallExpr{Fun: id, Lparen: pos}
+			return StateArgument{}, nil
+		}
+		// the whole rule is written as a call such as has_key(labels, `team`)
+		if ok && ast.IsCallOp(id.Name) {
+			exp.X, exp.Op, exp.OpPos, exp.Lparen = nil, id.Name, id.NamePos, pos
+			return StateCallOperand{}, nil
+		}
+		return nil, errors.New(fmt.Sprintf("any, all, len or has_key is expected before `(` at %d", pos))
 	case token.IDENT:
-		exp.Operation = lit
-		break
+		exp.Op = lit
+		if ast.IsUnaryOp(lit) {
+			// the operation such as exists takes no value
+			exp.OpPos = pos
+			return StateEnd{}, nil
+		}
 	case token.EQL, token.LSS, token.GTR, token.NEQ, token.LEQ, token.GEQ:
-		exp.Operation = tok.String()
+		exp.Op = tok.String()
 		break
+	case token.TILDE:
+		// the regular expression match "=~" is merged into a single token by the lexer
+		if lit != "=~" {
+			return nil, errors.New(fmt.Sprintf("operation is expected at %d, but %s is found", pos, tok.String()))
+		}
+		exp.Op = lit
 	default:
 		return nil, errors.New(fmt.Sprintf("operation is expected at %d, but %s is found", pos, tok.String()))
 	}
+	exp.OpPos = pos
 	return StateValue{}, nil
 }
 
-func (s StateValue) Run(pos token.Pos, tok token.Token, lit string, exp *RuleExpr) (
+func (s StateSelector) Run(pos token.Pos, tok token.Token, lit string, exp *ast.CmpExpr) (
+	State, error) {
+	if tok != token.IDENT {
+		return nil, errors.New(fmt.Sprintf("identifier is expected after `.` at %d", pos))
+	}
+	exp.X = &ast.SelectorExpr{X: exp.X, Sel: &ast.Ident{NamePos: pos, Name: lit}}
+	return StateOperation{}, nil
+}
+
+func (s StateArgument) Run(pos token.Pos, tok token.Token, lit string, exp *ast.CmpExpr) (
+	State, error) {
+	if tok != token.IDENT {
+		return nil, errors.New(fmt.Sprintf("identifier is expected at %d", pos))
+	}
+	exp.X.(*ast.CallExpr).Arg = &ast.Ident{NamePos: pos, Name: lit}
+	return StateArgumentEnd{}, nil
+}
+
+func (s StateArgumentEnd) Run(pos token.Pos, tok token.Token, lit string, exp *ast.CmpExpr) (
+	State, error) {
+	switch tok {
+	case token.PERIOD:
+		return StateArgumentSelector{}, nil
+	case token.RPAREN:
+		exp.X.(*ast.CallExpr).Rparen = pos
+		return StateOperation{}, nil
+	}
+	return nil, errors.New(fmt.Sprintf("`.` or `)` is expected at %d", pos))
+}
+
+func (s StateArgumentSelector) Run(pos token.Pos, tok token.Token, lit string, exp *ast.CmpExpr) (
+	State, error) {
+	if tok != token.IDENT {
+		return nil, errors.New(fmt.Sprintf("identifier is expected after `.` at %d", pos))
+	}
+	call := exp.X.(*ast.CallExpr)
+	call.Arg = &ast.SelectorExpr{X: call.Arg, Sel: &ast.Ident{NamePos: pos, Name: lit}}
+	return StateArgumentEnd{}, nil
+}
+
+func (s StateIndex) Run(pos token.Pos, tok token.Token, lit string, exp *ast.CmpExpr) (
+	State, error) {
+	if tok != token.STRING && tok != token.INT {
+		return nil, errors.New(fmt.Sprintf("string or integer key is expected at %d", pos))
+	}
+	key, err := literal(pos, tok, lit)
+	if err != nil {
+		return nil, err
+	}
+	exp.X.(*ast.IndexExpr).Index = key
+	return StateIndexEnd{Call: s.Call}, nil
+}
+
+func (s StateIndexEnd) Run(pos token.Pos, tok token.Token, lit string, exp *ast.CmpExpr) (
+	State, error) {
+	if tok != token.RBRACK {
+		return nil, errors.New(fmt.Sprintf("`]` is expected at %d", pos))
+	}
+	exp.X.(*ast.IndexExpr).Rbrack = pos
+	if s.Call {
+		return StateCallOperandEnd{}, nil
+	}
+	return StateOperation{}, nil
+}
+
+func (s StateCallOperand) Run(pos token.Pos, tok token.Token, lit string, exp *ast.CmpExpr) (
 	State, error) {
+	if tok != token.IDENT {
+		return nil, errors.New(fmt.Sprintf("identifier is expected at %d", pos))
+	}
+	exp.X = &ast.Ident{NamePos: pos, Name: lit}
+	return StateCallOperandEnd{}, nil
+}
 
+func (s StateCallOperandEnd) Run(pos token.Pos, tok token.Token, lit string, exp *ast.CmpExpr) (
+	State, error) {
+	_, index := exp.X.(*ast.IndexExpr)
+
+	switch tok {
+	case token.PERIOD:
+		if !index {
+			return StateCallSelector{}, nil
+		}
+	case token.LBRACK:
+		exp.X = &ast.IndexExpr{X: exp.X, Lbrack: pos}
+		return StateIndex{Call: true}, nil
+	case token.COMMA:
+		return StateCallValue{}, nil
+	}
+	return nil, errors.New(fmt.Sprintf("`,` is expected at %d", pos))
+}
+
+func (s StateCallSelector) Run(pos token.Pos, tok token.Token, lit string, exp *ast.CmpExpr) (
+	State, error) {
+	if tok != token.IDENT {
+		return nil, errors.New(fmt.Sprintf("identifier is expected after `.` at %d", pos))
+	}
+	exp.X = &ast.SelectorExpr{X: exp.X, Sel: &ast.Ident{NamePos: pos, Name: lit}}
+	return StateCallOperandEnd{}, nil
+}
+
+func (s StateCallValue) Run(pos token.Pos, tok token.Token, lit string, exp *ast.CmpExpr) (
+	State, error) {
+	value, err := literal(pos, tok, lit)
+	if err != nil {
+		return nil, err
+	}
+	exp.Y = value
+	return StateCallEnd{}, nil
+}
+
+func (s StateCallEnd) Run(pos token.Pos, tok token.Token, lit string, exp *ast.CmpExpr) (
+	State, error) {
+	if tok != token.RPAREN {
+		return nil, errors.New(fmt.Sprintf("`)` is expected at %d", pos))
+	}
+	exp.Rparen = pos
+	return StateEnd{}, nil
+}
+
+func (s StateValue) Run(pos token.Pos, tok token.Token, lit string, exp *ast.CmpExpr) (
+	State, error) {
+
+	// a list of values is braced with either brackets or parentheses
+	switch tok {
+	case token.LBRACK:
+		exp.Y = &ast.BasicLit{ValuePos: pos, Kind: ast.List}
+		return StateElement{Close: token.RBRACK}, nil
+	case token.LPAREN:
+		exp.Y = &ast.BasicLit{ValuePos: pos, Kind: ast.List}
+		return StateElement{Close: token.RPAREN}, nil
+	}
+
+	value, err := literal(pos, tok, lit)
+	if err != nil {
+		return nil, err
+	}
+	exp.Y = value
+
+	return StateEnd{}, nil
+}
+
+func (s StateElement) Run(pos token.Pos, tok token.Token, lit string, exp *ast.CmpExpr) (
+	State, error) {
+	value, err := literal(pos, tok, lit)
+	if err != nil {
+		return nil, err
+	}
+	exp.Y.Elts = append(exp.Y.Elts, value)
+	return StateElementEnd{Close: s.Close}, nil
+}
+
+func (s StateElementEnd) Run(pos token.Pos, tok token.Token, lit string, exp *ast.CmpExpr) (
+	State, error) {
+	switch tok {
+	case token.COMMA:
+		return StateElement{Close: s.Close}, nil
+	case s.Close:
+		list, err := ast.NewListLit(exp.Y.ValuePos, exp.Y.Elts, pos)
+		if err != nil {
+			return nil, err
+		}
+		exp.Y = list
+		return StateEnd{}, nil
+	}
+	return nil, errors.New(fmt.Sprintf("`,` or `%s` is expected at %d", s.Close.String(), pos))
+}
+
+// literal returns the literal value of the token.
+func literal(pos token.Pos, tok token.Token, lit string) (*ast.BasicLit, error) {
 	var val string
+	var kind ast.LitKind
 
 	if tok == token.STRING {
 
@@ -77,30 +359,38 @@ func (s StateValue) Run(pos token.Pos, tok token.Token, lit string, exp *RuleExp
 		if val == "" {
 			return nil, errors.New(fmt.Sprintf("operation %s is empty", lit))
 		}
-		exp.Value = val
-
-		return StateEnd{}, nil
+		kind = ast.String
 
-	} else if tok == token.INT || tok == token.FLOAT {
-		exp.Value = exp.Value + lit
-		return StateEnd{}, nil
+	} else if tok == token.INT {
+		// negative numbers are merged with their sign by the lexer
+		val, kind = lit, ast.Int
+	} else if tok == token.FLOAT && (strings.Count(lit, ".") > 1 || lit[0] == 'v') {
+		// semantic versions are merged into a single token by the lexer of the parser
+		val, kind = lit, ast.Version
+	} else if tok == token.FLOAT {
+		val, kind = lit, ast.Float
 	} else if lit == "true" || lit == "false" {
-		exp.Value = lit
-		return StateEnd{}, nil
-	} else if tok == token.SUB {
-		exp.Value = "-"
-		return StateValue{}, nil
+		val, kind = lit, ast.Bool
+	} else if tok == token.IDENT && lit == "nil" {
+		val, kind = lit, ast.Nil
+	} else {
+		return nil, errors.New(fmt.Sprintf("%s is not accepted as the value", tok.String()))
 	}
 
-	return nil, errors.New(fmt.Sprintf("%s is not accepted as the value", tok.String()))
+	return ast.NewBasicLit(pos, kind, val)
 }
 
-func (s StateEnd) Run(pos token.Pos, tok token.Token, lit string, exp *RuleExpr) (
+func (s StateEnd) Run(pos token.Pos, tok token.Token, lit string, exp *ast.CmpExpr) (
 	State, error) {
 
-	if tok != token.SEMICOLON {
-		return nil, errors.New(fmt.Sprintf("`;` is expected at %d", pos))
+	switch tok {
+	case token.SEMICOLON, token.LAND, token.LOR:
+		// a logical operator is followed by another rule
+		return StateOperand{}, nil
+	case token.RPAREN:
+		// closing a group completes it like a single rule
+		return StateEnd{}, nil
 	}
 
-	return StateOperand{}, nil
+	return nil, errors.New(fmt.Sprintf("`;`, `&&`, `||` or `)` is expected at %d", pos))
 }